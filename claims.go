@@ -0,0 +1,261 @@
+package goauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClaimMapping tells a provider where each UserData field is found in the user
+// information returned by the provider. Each field lists paths which are tried
+// in order until one of them has a value. A path is either a dotted path (eg:
+// picture.data.url) or a JSON Pointer (eg: /picture/data/url), which is needed
+// when a name contains a dot. Array elements are selected by their index (eg:
+// emails.0.value). Fields left empty use the default mapping.
+type ClaimMapping struct {
+
+	// UserID defaults to id.
+	UserID []string
+
+	// Email defaults to email.
+	Email []string
+
+	// EmailVerified is whether the provider has verified the email address. Defaults
+	// to email_verified or verified_email.
+	EmailVerified []string
+
+	// Emails are lists of the user's email addresses, such as the response to a
+	// further UserInfoRequest. Defaults to emails.
+	Emails []string
+
+	// FullName defaults to name, or the given and family names.
+	FullName []string
+
+	// GivenName defaults to given_name or first_name.
+	GivenName []string
+
+	// FamilyName defaults to family_name or last_name.
+	FamilyName []string
+
+	// ScreenName defaults to screen_name, or the full name.
+	ScreenName []string
+
+	// PhotoURL defaults to picture.data.url, picture or profile_image_url.
+	PhotoURL []string
+}
+
+// defaultClaimMapping matches the user information of the common providers.
+var defaultClaimMapping = ClaimMapping{
+	UserID:        []string{"id"},
+	Email:         []string{"email"},
+	EmailVerified: []string{"email_verified", "verified_email"},
+	Emails:        []string{"emails"},
+	FullName:      []string{"name"},
+	GivenName:     []string{"given_name", "first_name"},
+	FamilyName:    []string{"family_name", "last_name"},
+	ScreenName:    []string{"screen_name"},
+	PhotoURL:      []string{"picture.data.url", "picture", "profile_image_url"},
+}
+
+// withDefaults returns the mapping with the empty fields set to the default.
+func (m ClaimMapping) withDefaults() ClaimMapping {
+	defaultPaths := func(paths, defaults []string) []string {
+		if len(paths) == 0 {
+			return defaults
+		}
+		return paths
+	}
+	return ClaimMapping{
+		UserID:        defaultPaths(m.UserID, defaultClaimMapping.UserID),
+		Email:         defaultPaths(m.Email, defaultClaimMapping.Email),
+		EmailVerified: defaultPaths(m.EmailVerified, defaultClaimMapping.EmailVerified),
+		Emails:        defaultPaths(m.Emails, defaultClaimMapping.Emails),
+		FullName:      defaultPaths(m.FullName, defaultClaimMapping.FullName),
+		GivenName:     defaultPaths(m.GivenName, defaultClaimMapping.GivenName),
+		FamilyName:    defaultPaths(m.FamilyName, defaultClaimMapping.FamilyName),
+		ScreenName:    defaultPaths(m.ScreenName, defaultClaimMapping.ScreenName),
+		PhotoURL:      defaultPaths(m.PhotoURL, defaultClaimMapping.PhotoURL),
+	}
+}
+
+// userData maps the user information to a UserData. The raw JSON is created
+// from the data when the provider did not send JSON.
+func (m ClaimMapping) userData(data map[string]interface{}, raw []byte) UserData {
+	if raw == nil {
+		raw, _ = json.Marshal(data)
+	}
+	user := UserData{
+		Raw:        data,
+		RawJSON:    raw,
+		UserID:     lookupClaims(data, m.UserID),
+		Email:      lookupClaims(data, m.Email),
+		FullName:   lookupClaims(data, m.FullName),
+		GivenName:  lookupClaims(data, m.GivenName),
+		FamilyName: lookupClaims(data, m.FamilyName),
+		ScreenName: lookupClaims(data, m.ScreenName),
+		PhotoURL:   lookupClaims(data, m.PhotoURL),
+	}
+	user.EmailVerified = lookupBool(data, m.EmailVerified)
+	user.Emails = emailAddresses(data, m.Emails)
+	if len(user.Email) == 0 {
+		if email, found := preferredEmail(user.Emails); found {
+			user.Email = email.Address
+		}
+	}
+	if len(user.Email) > 0 {
+		found := false
+		for i, email := range user.Emails {
+			if email.Address == user.Email {
+				user.EmailVerified = user.EmailVerified || email.Verified
+				user.Emails[i].Verified = user.EmailVerified
+				found = true
+			}
+		}
+		if !found {
+			user.Emails = append([]EmailAddress{{Address: user.Email, Verified: user.EmailVerified, Primary: true}}, user.Emails...)
+		}
+	}
+	if len(user.FullName) == 0 {
+		if len(user.FamilyName) > 0 {
+			user.FullName = fmt.Sprintf("%v %v", user.GivenName, user.FamilyName)
+		}
+	}
+	if len(user.ScreenName) == 0 {
+		user.ScreenName = user.FullName
+	}
+	return user
+}
+
+// preferredEmail returns the primary verified email address, or else the first
+// verified, primary or any address.
+func preferredEmail(emails []EmailAddress) (EmailAddress, bool) {
+	matches := []func(EmailAddress) bool{
+		func(e EmailAddress) bool { return e.Primary && e.Verified },
+		func(e EmailAddress) bool { return e.Verified },
+		func(e EmailAddress) bool { return e.Primary },
+		func(e EmailAddress) bool { return true },
+	}
+	for _, match := range matches {
+		for _, email := range emails {
+			if match(email) {
+				return email, true
+			}
+		}
+	}
+	return EmailAddress{}, false
+}
+
+// lookupClaims returns the first of the paths with a string or number value.
+func lookupClaims(data map[string]interface{}, paths []string) string {
+	for _, path := range paths {
+		switch value := lookupClaim(data, path).(type) {
+		case string:
+			if len(value) > 0 {
+				return value
+			}
+		case float64, float32, int64, int32, int:
+			return toStringValue(value)
+		}
+	}
+	return ""
+}
+
+// lookupClaim returns the value found at a dotted path or JSON Pointer, or nil.
+func lookupClaim(data map[string]interface{}, path string) interface{} {
+	var segments []string
+	if strings.HasPrefix(path, "/") {
+		segments = strings.Split(path[1:], "/")
+		for i, segment := range segments {
+			segments[i] = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+		}
+	} else {
+		segments = strings.Split(path, ".")
+	}
+
+	var value interface{} = data
+	for _, segment := range segments {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[segment]
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil
+			}
+			value = v[index]
+		default:
+			return nil
+		}
+	}
+	return value
+}
+
+// Claim returns the user information found at a dotted path or JSON Pointer, or
+// nil if there is none.
+func (u UserData) Claim(path string) interface{} {
+	if u.Raw == nil {
+		return nil
+	}
+	return lookupClaim(u.Raw, path)
+}
+
+// ClaimString returns the user information found at the path as a string. Numbers
+// and booleans are formatted, anything else is returned as an empty string.
+func (u UserData) ClaimString(path string) string {
+	switch value := u.Claim(path).(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case float64, float32, int64, int32, int:
+		return toStringValue(value)
+	}
+	return ""
+}
+
+// ClaimBool returns the user information found at the path as a boolean. The
+// strings true and false are accepted, since some providers send them.
+func (u UserData) ClaimBool(path string) (bool, bool) {
+	switch value := u.Claim(path).(type) {
+	case bool:
+		return value, true
+	case string:
+		b, err := strconv.ParseBool(value)
+		return b, err == nil
+	}
+	return false, false
+}
+
+// ClaimInt returns the user information found at the path as an integer. Numeric
+// strings are accepted, since some providers send large ids as strings.
+func (u UserData) ClaimInt(path string) (int64, bool) {
+	switch value := u.Claim(path).(type) {
+	case float64:
+		if value == float64(int64(value)) {
+			return int64(value), true
+		}
+	case string:
+		i, err := strconv.ParseInt(value, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// ClaimStrings returns the strings in the array found at the path. A single
+// string is returned as a one element slice.
+func (u UserData) ClaimStrings(path string) []string {
+	switch value := u.Claim(path).(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		strs := make([]string, 0, len(value))
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				strs = append(strs, str)
+			}
+		}
+		return strs
+	}
+	return nil
+}
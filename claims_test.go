@@ -0,0 +1,112 @@
+package goauth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testGitHubProfile = `{
+  "login": "octocat",
+  "id": 583231,
+  "avatar_url": "https://avatars.githubusercontent.com/u/583231",
+  "name": null,
+  "email": "octocat@github.com",
+  "links": {"html.url": "https://github.com/octocat"},
+  "emails": [{"value": "octocat@example.com"}]
+}`
+
+func TestClaimMapping(t *testing.T) {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(testGitHubProfile), &data); err != nil {
+		t.Fatal(err)
+	}
+	mapping := ClaimMapping{
+		FullName:   []string{"name", "login"},
+		ScreenName: []string{"login"},
+		PhotoURL:   []string{"avatar_url"},
+	}.withDefaults()
+	user := mapping.userData(data, nil)
+	if user.UserID != "583231" || user.FullName != "octocat" || user.ScreenName != "octocat" ||
+		user.PhotoURL != "https://avatars.githubusercontent.com/u/583231" || user.Email != "octocat@github.com" {
+		t.Logf("Invalid user %v.", user)
+		t.Fail()
+	}
+
+	paths := map[string]string{
+		"/links/html.url": "https://github.com/octocat",
+		"emails.0.value":  "octocat@example.com",
+		"/emails/0/value": "octocat@example.com",
+		"emails.1.value":  "",
+		"login.first":     "",
+		"missing":         "",
+	}
+	for path, expected := range paths {
+		if value := lookupClaims(data, []string{path}); value != expected {
+			t.Logf("Expecting %v at %v but found %v.", expected, path, value)
+			t.Fail()
+		}
+	}
+}
+
+func TestDefaultClaimMapping(t *testing.T) {
+	data := map[string]interface{}{
+		"id":         "12345",
+		"first_name": "Jane",
+		"last_name":  "Doe",
+		"picture":    map[string]interface{}{"data": map[string]interface{}{"url": "https://example.com/jane.png"}},
+	}
+	user := defaultClaimMapping.userData(data, nil)
+	if user.FullName != "Jane Doe" || user.ScreenName != "Jane Doe" || user.PhotoURL != "https://example.com/jane.png" {
+		t.Logf("Invalid user %v.", user)
+		t.Fail()
+	}
+}
+
+func TestUserDataClaims(t *testing.T) {
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(`{"id":"9007199254740993","verified":"true","site_admin":false,"followers":12,"score":1.5,"bio":null,"roles":["admin",3,"user"]}`), &data); err != nil {
+		t.Fatal(err)
+	}
+	user := defaultClaimMapping.userData(data, nil)
+
+	if id, ok := user.ClaimInt("id"); !ok || id != 9007199254740993 {
+		t.Logf("Invalid id %v.", id)
+		t.Fail()
+	}
+	if followers, ok := user.ClaimInt("followers"); !ok || followers != 12 {
+		t.Logf("Invalid followers %v.", followers)
+		t.Fail()
+	}
+	if _, ok := user.ClaimInt("score"); ok {
+		t.Log("Expecting a fractional number not to be an integer.")
+		t.Fail()
+	}
+	if verified, ok := user.ClaimBool("verified"); !ok || !verified {
+		t.Log("Expecting the string true to be a boolean.")
+		t.Fail()
+	}
+	if admin, ok := user.ClaimBool("site_admin"); !ok || admin {
+		t.Log("Expecting site_admin to be false.")
+		t.Fail()
+	}
+	if _, ok := user.ClaimBool("bio"); ok {
+		t.Log("Expecting null not to be a boolean.")
+		t.Fail()
+	}
+	if bio := user.ClaimString("bio"); bio != "" {
+		t.Logf("Expecting null to be an empty string but found %v.", bio)
+		t.Fail()
+	}
+	if score := user.ClaimString("score"); score != "1.5" {
+		t.Logf("Invalid score %v.", score)
+		t.Fail()
+	}
+	if roles := user.ClaimStrings("roles"); len(roles) != 2 || roles[0] != "admin" || roles[1] != "user" {
+		t.Logf("Invalid roles %v.", roles)
+		t.Fail()
+	}
+	if len(user.RawJSON) == 0 || (UserData{}).Claim("id") != nil {
+		t.Log("Expecting the raw JSON to be created and an empty user to have no claims.")
+		t.Fail()
+	}
+}
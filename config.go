@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -13,8 +12,35 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// ConfigLoader configures providers from JSON or YAML files. Every string in the
+// files may refer to environment variables as ${VAR}, or as ${VAR:-default} to
+// use a default when the variable is unset or empty. A string starting with
+// file:// (eg: file:///run/secrets/google_client_secret) is replaced by the
+// contents of the file, for secrets mounted by Docker or Kubernetes.
+type ConfigLoader struct {
+
+	// EnvPrefix is prepended to the names of the environment variables the client
+	// id and secret are read from when they are not in the file (eg: the prefix
+	// MYAPP_ reads MYAPP_GOOGLE_CLIENT_ID).
+	EnvPrefix string
+
+	// LookupEnv returns the value of an environment variable. Defaults to
+	// os.LookupEnv.
+	LookupEnv func(key string) (string, bool)
+}
+
 // ConfigureProvidersFromJSON configures a map of providers using a JSON file.
 func ConfigureProvidersFromJSON(fileReader io.Reader, callbackURL string) (map[string]OAuthServiceProvider, error) {
+	return ConfigLoader{}.ConfigureProvidersFromJSON(fileReader, callbackURL)
+}
+
+// ConfigureProvidersFromYAML configures a map of providers using a YAML file.
+func ConfigureProvidersFromYAML(fileReader io.Reader, callbackURL string) (map[string]OAuthServiceProvider, error) {
+	return ConfigLoader{}.ConfigureProvidersFromYAML(fileReader, callbackURL)
+}
+
+// ConfigureProvidersFromJSON configures a map of providers using a JSON file.
+func (loader ConfigLoader) ConfigureProvidersFromJSON(fileReader io.Reader, callbackURL string) (map[string]OAuthServiceProvider, error) {
 	m := make(map[string]map[string]interface{})
 	dec := json.NewDecoder(fileReader)
 	err := dec.Decode(&m)
@@ -22,11 +48,11 @@ func ConfigureProvidersFromJSON(fileReader io.Reader, callbackURL string) (map[s
 		return make(map[string]OAuthServiceProvider, 0), err
 	}
 
-	return makeProvidersFromMap(m, callbackURL)
+	return loader.makeProvidersFromMap(m, callbackURL)
 }
 
 // ConfigureProvidersFromYAML configures a map of providers using a YAML file.
-func ConfigureProvidersFromYAML(fileReader io.Reader, callbackURL string) (map[string]OAuthServiceProvider, error) {
+func (loader ConfigLoader) ConfigureProvidersFromYAML(fileReader io.Reader, callbackURL string) (map[string]OAuthServiceProvider, error) {
 	m := make(map[string]map[string]interface{})
 	data, err := ioutil.ReadAll(fileReader)
 	if err != nil {
@@ -36,23 +62,31 @@ func ConfigureProvidersFromYAML(fileReader io.Reader, callbackURL string) (map[s
 		return make(map[string]OAuthServiceProvider, 0), err
 	}
 
-	return makeProvidersFromMap(m, callbackURL)
+	return loader.makeProvidersFromMap(m, callbackURL)
 }
 
-func makeProvidersFromMap(m map[string]map[string]interface{}, callbackURL string) (map[string]OAuthServiceProvider, error) {
+func (loader ConfigLoader) makeProvidersFromMap(m map[string]map[string]interface{}, callbackURLPattern string) (map[string]OAuthServiceProvider, error) {
 	providers := make(map[string]OAuthServiceProvider, len(m))
 
 	for provider, conf := range m {
+		// replace the environment variables and secret files before anything reads the values
+		if _, err := loader.interpolateValue(conf); err != nil {
+			return providers, fmt.Errorf("Could not configure the provider %s: %v", provider, err)
+		}
 		providerName := strings.ToLower(provider)
 		conf["ProviderName"] = providerName
-		conf["RedirectURL"] = fmt.Sprintf(callbackURL, providerName)
+		conf["RedirectURL"] = callbackURL(callbackURLPattern, providerName)
 		// if the clientID is not in the file data get it from the environment variables
 		if _, found := conf["ClientID"]; !found {
-			conf["ClientID"] = os.Getenv(strings.ToUpper(provider) + "_CLIENT_ID")
+			if clientID, found := loader.lookupEnv(loader.EnvPrefix + strings.ToUpper(provider) + "_CLIENT_ID"); found {
+				conf["ClientID"] = clientID
+			}
 		}
 		// if the client secret is not in the file data get it from the environment variables
 		if _, found := conf["ClientSecret"]; !found {
-			conf["ClientSecret"] = os.Getenv(strings.ToUpper(provider) + "_CLIENT_SECRET")
+			if clientSecret, found := loader.lookupEnv(loader.EnvPrefix + strings.ToUpper(provider) + "_CLIENT_SECRET"); found {
+				conf["ClientSecret"] = clientSecret
+			}
 		}
 
 		// if client id or secret is still not set throw error
@@ -62,6 +96,19 @@ func makeProvidersFromMap(m map[string]map[string]interface{}, callbackURL strin
 		if _, found := conf["ClientSecret"]; !found {
 			return providers, fmt.Errorf("No Client Secret could be found for the provider %s.", provider)
 		}
+		preset, err := presetFromMap(conf, provider)
+		if err != nil {
+			return providers, err
+		}
+		if _, found := conf["OAuthVersion"]; !found && preset != nil {
+			conf["OAuthVersion"], _ = strconv.ParseFloat(preset.OAuthVersion, 64)
+		}
+		if version, ok := conf["OAuthVersion"].(string); ok {
+			// an interpolated version is a string
+			if f, err := strconv.ParseFloat(version, 64); err == nil {
+				conf["OAuthVersion"] = f
+			}
+		}
 		oauthVersion, found := conf["OAuthVersion"]
 		if !found {
 			return providers, fmt.Errorf("No OAuth Version found for provider %s.", provider)
@@ -70,22 +117,39 @@ func makeProvidersFromMap(m map[string]map[string]interface{}, callbackURL strin
 			return providers, fmt.Errorf("The OAuth Version %v for provider %s is not a float.", oauthVersion, provider)
 		}
 		oauthVersionString := strconv.FormatFloat(oauthVersion.(float64), 'f', 1, 32)
+		if preset != nil && preset.OAuthVersion != oauthVersionString {
+			return providers, fmt.Errorf("The preset %v for provider %s is an OAuth %v provider.", preset.Name, provider, preset.OAuthVersion)
+		}
 		switch oauthVersionString {
 		case OAuthVersion1:
 			// build version 1.0
 			oauthConfiguration := OAuth1ServiceProviderConfig{}
+			if preset != nil {
+				oauthConfiguration = preset.OAuth1Config("", "", "")
+			}
 			err := configureNewOAuthServiceProvider(&oauthConfiguration, conf)
 			if err != nil {
 				return providers, err
 			}
+			// report an invalid signature method or private key now rather than at login
+			if _, err = newSigner(oauthConfiguration); err != nil {
+				return providers, err
+			}
 			providers[providerName] = NewOAuth1ServiceProvider(oauthConfiguration)
 		case OAuthVersion2:
 			// build version 2.0
 			oauthConfiguration := OAuth2ServiceProviderConfig{}
+			if preset != nil {
+				oauthConfiguration = preset.OAuth2Config("", "", "")
+			}
 			err := configureNewOAuthServiceProvider(&oauthConfiguration, conf)
 			if err != nil {
 				return providers, err
 			}
+			// report an unsupported PKCE method now rather than at login
+			if err = validatePKCEMethod(oauthConfiguration.PKCEMethod); err != nil {
+				return providers, err
+			}
 			providers[providerName] = NewOAuth2ServiceProvider(oauthConfiguration)
 		default:
 			return providers, fmt.Errorf("Invalid OAuth version %v for provider %v.", oauthVersionString, provider)
@@ -94,6 +158,29 @@ func makeProvidersFromMap(m map[string]map[string]interface{}, callbackURL strin
 	return providers, nil
 }
 
+// presetFromMap returns the preset named by the Preset key, with the Domain and
+// Tenant keys applied, or nil if there is no Preset key.
+func presetFromMap(conf map[string]interface{}, provider string) (*Preset, error) {
+	name, found := conf["Preset"]
+	if !found {
+		return nil, nil
+	}
+	preset, found := LookupPreset(fmt.Sprint(name))
+	if !found {
+		return nil, fmt.Errorf("Unknown preset %v for provider %s.", name, provider)
+	}
+	if domain, found := conf["Domain"]; found {
+		preset.Domain = fmt.Sprint(domain)
+	}
+	if tenant, found := conf["Tenant"]; found {
+		preset.Tenant = fmt.Sprint(tenant)
+	}
+	if err := preset.validate(); err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
 // use reflection to configure providers.
 func configureNewOAuthServiceProvider(configPtr interface{}, conf map[string]interface{}) error {
 	v := reflect.ValueOf(configPtr)
@@ -114,17 +201,80 @@ func configureNewOAuthServiceProvider(configPtr interface{}, conf map[string]int
 			case reflect.String:
 				field.SetString(fieldVal.(string))
 			case reflect.Int:
-				val, _ := strconv.Atoi(fieldVal.(string))
-				field.SetInt(int64(val))
+				switch val := fieldVal.(type) {
+				case string:
+					i, _ := strconv.Atoi(val)
+					field.SetInt(int64(i))
+				case float64:
+					field.SetInt(int64(val))
+				case int:
+					field.SetInt(int64(val))
+				}
+			case reflect.Bool:
+				switch val := fieldVal.(type) {
+				case string:
+					b, _ := strconv.ParseBool(val)
+					field.SetBool(b)
+				case bool:
+					field.SetBool(val)
+				}
 			case reflect.Slice:
-				vals := fieldVal.([]interface{})
-				strs := make([]string, len(vals))
-				for i, strVal := range vals {
-					strs[i] = strVal.(string)
+				if field.Type().Elem().Kind() == reflect.Struct {
+					// lists of nested structs such as the user information requests
+					vals, ok := fieldVal.([]interface{})
+					if !ok {
+						return fmt.Errorf("The value of %v must be a list.", fieldName)
+					}
+					structs := reflect.MakeSlice(field.Type(), len(vals), len(vals))
+					for i, val := range vals {
+						m, ok := toStringMap(val)
+						if !ok {
+							return fmt.Errorf("The values of %v must be maps.", fieldName)
+						}
+						if err := configureNewOAuthServiceProvider(structs.Index(i).Addr().Interface(), m); err != nil {
+							return err
+						}
+					}
+					field.Set(structs)
+					break
+				}
+				switch vals := fieldVal.(type) {
+				case string:
+					field.Set(reflect.ValueOf([]string{vals}))
+				case []interface{}:
+					strs := make([]string, len(vals))
+					for i, strVal := range vals {
+						strs[i] = strVal.(string)
+					}
+					field.Set(reflect.ValueOf(strs))
+				}
+			case reflect.Struct:
+				// nested structs such as the claim mapping
+				m, ok := toStringMap(fieldVal)
+				if !ok {
+					return fmt.Errorf("The value of %v must be a map.", fieldName)
+				}
+				if err := configureNewOAuthServiceProvider(field.Addr().Interface(), m); err != nil {
+					return err
 				}
-				field.Set(reflect.ValueOf(strs))
 			}
 		}
 	}
 	return nil
 }
+
+// toStringMap converts a decoded JSON or YAML map to a map with string keys.
+func toStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch vals := value.(type) {
+	case map[string]interface{}:
+		return vals, true
+	case map[interface{}]interface{}:
+		// YAML decodes nested maps with interface keys
+		m := make(map[string]interface{}, len(vals))
+		for key, val := range vals {
+			m[fmt.Sprint(key)] = val
+		}
+		return m, true
+	}
+	return nil, false
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"testing"
 )
 
 func ExampleConfigureProvidersFromJSON() {
@@ -118,3 +119,100 @@ TWITTER:
 	// The provider for facebook is a version 2.0 provider named FACEBOOK.
 	// The provider for twitter is a version 1.0 provider named TWITTER.
 }
+
+func TestConfigurePKCEMethod(t *testing.T) {
+	jsonString := `{
+   "Test":{
+      "OAuthVersion":2.0,
+      "ClientID":"abc123",
+      "ClientSecret":"xyz456",
+      "AuthURL":"https://example.com/oauth2/auth",
+      "TokenURL":"https://example.com/oauth2/token",
+      "UserInfoURL":"https://example.com/oauth2/userinfo",
+      "PKCEMethod":"S256"
+   }
+}`
+	providers, err := ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	provider := providers["test"].(*OAuth2ServiceProvider)
+	if provider.pkceMethod != PKCEMethodS256 {
+		t.Logf("Expecting PKCE method to be %v but was %v.", PKCEMethodS256, provider.pkceMethod)
+		t.Fail()
+	}
+
+	jsonString = `{"Test":{"OAuthVersion":2.0,"ClientID":"abc123","ClientSecret":"xyz456","PKCEMethod":"S512"}}`
+	if _, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil {
+		t.Log("Expecting an error for an unsupported PKCE method.")
+		t.Fail()
+	}
+}
+
+func TestConfigureStateMaxAge(t *testing.T) {
+	yamlString := `TEST:
+  OAuthVersion:       2.0
+  ClientID:           abc123
+  ClientSecret:       xyz456
+  StateMaxAgeSeconds: 600`
+	providers, err := ConfigureProvidersFromYAML(strings.NewReader(yamlString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if maxAge := providers["test"].(*OAuth2ServiceProvider).stateMaxAge; maxAge != 600 {
+		t.Logf("Expecting state max age to be 600 but was %v.", maxAge)
+		t.Fail()
+	}
+
+	jsonString := `{"Test":{"OAuthVersion":2.0,"ClientID":"abc123","ClientSecret":"xyz456","StateMaxAgeSeconds":900}}`
+	providers, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if maxAge := providers["test"].(*OAuth2ServiceProvider).stateMaxAge; maxAge != 900 {
+		t.Logf("Expecting state max age to be 900 but was %v.", maxAge)
+		t.Fail()
+	}
+}
+
+func TestConfigureClaims(t *testing.T) {
+	yamlString := `Test:
+  OAuthVersion: 2.0
+  ClientID:     abc123
+  ClientSecret: xyz456
+  Claims:
+    ScreenName: login
+    PhotoURL:
+      - avatar_url
+      - /links/avatar.href`
+	providers, err := ConfigureProvidersFromYAML(strings.NewReader(yamlString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	claims := providers["test"].(*OAuth2ServiceProvider).claims
+	if len(claims.ScreenName) != 1 || claims.ScreenName[0] != "login" || len(claims.PhotoURL) != 2 || claims.UserID[0] != "id" {
+		t.Logf("Invalid claim mapping %v.", claims)
+		t.Fail()
+	}
+
+	jsonString := `{"Test":{"OAuthVersion":1.0,"ClientID":"abc123","ClientSecret":"xyz456","Claims":{"UserID":["id_str","id"]}}}`
+	providers, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if claims := providers["test"].(*OAuth1ServiceProvider).config.Claims; len(claims.UserID) != 2 || claims.UserID[0] != "id_str" {
+		t.Logf("Invalid claim mapping %v.", claims)
+		t.Fail()
+	}
+
+	jsonString = `{"Test":{"OAuthVersion":2.0,"ClientID":"abc123","ClientSecret":"xyz456","Claims":"login"}}`
+	if _, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil {
+		t.Log("Expecting an error for an invalid claim mapping.")
+		t.Fail()
+	}
+}
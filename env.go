@@ -0,0 +1,113 @@
+package goauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// the prefix of values which are read from a file.
+const secretFilePrefix = "file://"
+
+// interpolateValue replaces the environment variable references in every string
+// of a decoded JSON or YAML value, and reads the values which refer to files.
+func (loader ConfigLoader) interpolateValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return loader.interpolateString(v)
+	case map[string]interface{}:
+		for key, val := range v {
+			interpolated, err := loader.interpolateValue(val)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = interpolated
+		}
+	case map[interface{}]interface{}:
+		for key, val := range v {
+			interpolated, err := loader.interpolateValue(val)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = interpolated
+		}
+	case []interface{}:
+		for i, val := range v {
+			interpolated, err := loader.interpolateValue(val)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = interpolated
+		}
+	}
+	return value, nil
+}
+
+// interpolateString replaces each ${VAR} with the value of the environment
+// variable, and each ${VAR:-default} with the default when the variable is unset
+// or empty. $${ is replaced by ${. When the result starts with file:// it is
+// replaced by the contents of the file, without the trailing line break.
+func (loader ConfigLoader) interpolateString(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			break
+		}
+		if start > 0 && s[start-1] == '$' {
+			b.WriteString(s[:start-1])
+			b.WriteString("${")
+			s = s[start+2:]
+			continue
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("The environment variable reference in %v is not closed.", s)
+		}
+		b.WriteString(s[:start])
+		value, err := loader.lookupReference(s[start+2 : start+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(value)
+		s = s[start+end+1:]
+	}
+
+	value := b.String()
+	if strings.HasPrefix(value, secretFilePrefix) {
+		data, err := ioutil.ReadFile(strings.TrimPrefix(value, secretFilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("Could not read the secret file: %v.", err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+	return value, nil
+}
+
+// lookupReference returns the value of a VAR or VAR:-default reference.
+func (loader ConfigLoader) lookupReference(reference string) (string, error) {
+	name, defaultValue, hasDefault := reference, "", false
+	if i := strings.Index(reference, ":-"); i >= 0 {
+		name, defaultValue, hasDefault = reference[:i], reference[i+2:], true
+	}
+	if len(name) == 0 {
+		return "", fmt.Errorf("The environment variable reference ${%v} has no name.", reference)
+	}
+	value, found := loader.lookupEnv(name)
+	if hasDefault && len(value) == 0 {
+		return defaultValue, nil
+	}
+	if !found {
+		return "", fmt.Errorf("The environment variable %v is not set.", name)
+	}
+	return value, nil
+}
+
+func (loader ConfigLoader) lookupEnv(name string) (string, bool) {
+	if loader.LookupEnv != nil {
+		return loader.LookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
@@ -0,0 +1,106 @@
+package goauth
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	}
+}
+
+func TestInterpolateString(t *testing.T) {
+	loader := ConfigLoader{LookupEnv: testEnv(map[string]string{"HOST": "myhost", "PORT": "8080", "EMPTY": ""})}
+	tests := map[string]string{
+		"plain":                           "plain",
+		"http://${HOST}:${PORT}/callback": "http://myhost:8080/callback",
+		"${MISSING:-localhost}":           "localhost",
+		"${EMPTY:-default}":               "default",
+		"${EMPTY}":                        "",
+		"${HOST:-}":                       "myhost",
+		"$${HOST} costs $5":               "${HOST} costs $5",
+	}
+	for s, expected := range tests {
+		if value, err := loader.interpolateString(s); err != nil || value != expected {
+			t.Logf("Expecting %v from %v but found %v (%v).", expected, s, value, err)
+			t.Fail()
+		}
+	}
+
+	for _, s := range []string{"${MISSING}", "${HOST", "${:-default}", "file://missing.txt"} {
+		if value, err := loader.interpolateString(s); err == nil {
+			t.Logf("Expecting an error interpolating %v but found %v.", s, value)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigLoader(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "client_secret")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	loader := ConfigLoader{
+		EnvPrefix: "MYAPP_",
+		LookupEnv: testEnv(map[string]string{
+			"MYAPP_GOOGLE_CLIENT_ID":     "google-id",
+			"MYAPP_GOOGLE_CLIENT_SECRET": "google-secret",
+			"GOOGLE_CLIENT_ID":           "unprefixed-id",
+			"OKTA_DOMAIN":                "example.okta.com",
+			"SECRET_FILE":                secretFile,
+			"VERSION":                    "2.0",
+		}),
+	}
+
+	yamlString := `Google:
+  OAuthVersion: ${VERSION}
+  AuthURL:      https://accounts.google.com/o/oauth2/auth
+  TokenURL:     https://accounts.google.com/o/oauth2/token
+  Scopes:
+    - ${SCOPE:-email}
+Okta:
+  Preset:       okta
+  Domain:       ${OKTA_DOMAIN}
+  ClientID:     ${OKTA_CLIENT_ID:-okta-id}
+  ClientSecret: file://${SECRET_FILE}
+  Claims:
+    Email: ${EMAIL_CLAIM:-upn}`
+	providers, err := loader.ConfigureProvidersFromYAML(strings.NewReader(yamlString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	google := providers["google"].(*OAuth2ServiceProvider)
+	if google.conf.ClientID != "google-id" || google.conf.ClientSecret != "google-secret" || google.conf.Scopes[0] != "email" {
+		t.Logf("Invalid configuration %v.", google.conf)
+		t.Fail()
+	}
+	okta := providers["okta"].(*OAuth2ServiceProvider)
+	if okta.conf.ClientID != "okta-id" || okta.conf.ClientSecret != "s3cr3t" || okta.oidc == nil || okta.claims.Email[0] != "upn" {
+		t.Logf("Invalid configuration %v %v.", okta.conf, okta.claims)
+		t.Fail()
+	}
+
+	jsonString := `{"Test":{"OAuthVersion":2.0,"ClientID":"${TEST_CLIENT_ID}","ClientSecret":"xyz456"}}`
+	if _, err = loader.ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil || !strings.Contains(err.Error(), "TEST_CLIENT_ID") {
+		t.Logf("Expecting the unset variable to be reported but found %v.", err)
+		t.Fail()
+	}
+
+	// the client credentials must be in the configuration or the environment
+	jsonString = `{"Test":{"OAuthVersion":2.0,"ClientSecret":"xyz456"}}`
+	if _, err = loader.ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil || err.Error() != "No Client ID could be found for the provider Test." {
+		t.Logf("Expecting the missing client id to be reported but found %v.", err)
+		t.Fail()
+	}
+	jsonString = `{"Google":{"OAuthVersion":2.0,"ClientID":"abc123"}}`
+	if _, err = (ConfigLoader{LookupEnv: testEnv(nil)}).ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil || err.Error() != "No Client Secret could be found for the provider Google." {
+		t.Logf("Expecting the missing client secret to be reported but found %v.", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,172 @@
+package goauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// The errors returned by the providers wrap one of these errors, which can be
+// tested with errors.Is, with more detail about the failure.
+var (
+	// ErrStateInvalid is returned when the provider's response does not match an
+	// authentication request started by the server: the state flag or request
+	// token is missing, forged, already used or was issued to another browser.
+	ErrStateInvalid = errors.New("The authentication request could not be validated.")
+
+	// ErrAuthRequest is returned by GetRedirectURLContext when the authentication
+	// request could not be prepared with the provider: the OAuth 1.0 request token
+	// or the OpenID Connect configuration could not be fetched.
+	ErrAuthRequest = errors.New("Could not start the authentication request.")
+
+	// ErrStateExpired is returned when the user took too long to authenticate.
+	ErrStateExpired = errors.New("The authentication request has expired.")
+
+	// ErrAccessDenied is returned when the user refused to let the application
+	// access their account.
+	ErrAccessDenied = errors.New("The user denied access.")
+
+	// ErrTokenExchange is returned when the provider could not be asked for an
+	// access token, or refused to issue one.
+	ErrTokenExchange = errors.New("Could not fetch the access token.")
+
+	// ErrUserInfo is returned when the user's information could not be fetched
+	// from the provider, or could not be validated.
+	ErrUserInfo = errors.New("Could not fetch the user information.")
+
+	// ErrResponseTooLarge is wrapped by the TransportError returned when a
+	// provider's response exceeds the size limit.
+	ErrResponseTooLarge = errors.New("The response is too large.")
+)
+
+// ProviderError is an error reported by an OAuth 2.0 provider, as described in
+// RFC 6749 sections 4.1.2.1 and 5.2. ProviderErrors with the access_denied code
+// match ErrAccessDenied.
+type ProviderError struct {
+	// Code is the error code (eg: invalid_grant).
+	Code string
+
+	// Description is the optional human readable description of the error.
+	Description string
+
+	// URI is the optional address of a page describing the error.
+	URI string
+
+	// State is the state flag sent back with an authorization error, which has
+	// been validated.
+	State string
+}
+
+func (e *ProviderError) Error() string {
+	if len(e.Description) == 0 {
+		return fmt.Sprintf("The provider returned the error %v.", e.Code)
+	}
+	return fmt.Sprintf("The provider returned the error %v: %v.", e.Code, strings.TrimSuffix(e.Description, "."))
+}
+
+// Is reports whether the error is the user denying access.
+func (e *ProviderError) Is(target error) bool {
+	return target == ErrAccessDenied && e.Code == "access_denied"
+}
+
+// OAuth1ProblemError is returned when an OAuth 1.0 provider rejects a request. The
+// Problem and Advice are set when the provider explains the rejection using the
+// OAuth problem reporting extension (eg: token_rejected). The permission_denied
+// and user_refused problems match ErrAccessDenied.
+type OAuth1ProblemError struct {
+	StatusCode int
+	Problem    string
+	Advice     string
+}
+
+func (e *OAuth1ProblemError) Error() string {
+	if len(e.Problem) == 0 {
+		return fmt.Sprintf("The provider rejected the request with status %v.", e.StatusCode)
+	}
+	if len(e.Advice) == 0 {
+		return fmt.Sprintf("The provider rejected the request: %v.", e.Problem)
+	}
+	return fmt.Sprintf("The provider rejected the request: %v (%v).", e.Problem, e.Advice)
+}
+
+// Is reports whether the error is the user denying access.
+func (e *OAuth1ProblemError) Is(target error) bool {
+	return target == ErrAccessDenied && (e.Problem == "permission_denied" || e.Problem == "user_refused")
+}
+
+// TransportError is returned when a request to a provider could not be completed,
+// wrapping the cause (eg: a timeout).
+type TransportError struct {
+	URL string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("The request to %v failed: %v", e.URL, e.Err)
+}
+
+// Unwrap returns the cause of the failure.
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// kindError attaches one of the exported errors to a detailed error, so that
+// errors.Is matches both while the message keeps the detail.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+// wrapError creates an error matching kind with the formatted message, which may
+// wrap a cause using %w.
+func wrapError(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
+
+// tokenExchangeError converts the errors returned by the oauth2 package, parsing
+// the provider's error response into a ProviderError.
+func tokenExchangeError(err error) error {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if providerErr := parseProviderError(retrieveErr.Body); providerErr != nil {
+			err = providerErr
+		}
+	}
+	return wrapError(ErrTokenExchange, "Could not fetch the access token: %w", err)
+}
+
+// parseProviderError parses a JSON or form encoded error response, returning nil
+// if the response does not contain an error code.
+func parseProviderError(body []byte) *ProviderError {
+	providerErr := &ProviderError{}
+	var fields struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+		ErrorURI         string `json:"error_uri"`
+	}
+	if err := json.Unmarshal(body, &fields); err == nil {
+		providerErr.Code, providerErr.Description, providerErr.URI = fields.Error, fields.ErrorDescription, fields.ErrorURI
+	} else if values, err := url.ParseQuery(string(body)); err == nil {
+		providerErr.Code, providerErr.Description, providerErr.URI = values.Get(oauth2Error), values.Get(oauth2ErrorDescription), values.Get(oauth2ErrorURI)
+	}
+	if len(providerErr.Code) == 0 {
+		return nil
+	}
+	return providerErr
+}
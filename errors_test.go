@@ -0,0 +1,43 @@
+package goauth
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := map[error]int{
+		&ProviderError{Code: "access_denied"}:                                                                 http.StatusForbidden,
+		&OAuth1ProblemError{Problem: "user_refused"}:                                                          http.StatusForbidden,
+		wrapError(ErrStateExpired, oauth2StateFlagError, "timed out"):                                         http.StatusBadRequest,
+		wrapError(ErrAuthRequest, "%w", errors.New("unreachable")):                                            http.StatusBadGateway,
+		wrapError(ErrTokenExchange, "%w", &ProviderError{Code: "invalid"}):                                    http.StatusBadGateway,
+		wrapError(ErrUserInfo, "%w", &TransportError{URL: "https://example.com", Err: errors.New("timeout")}): http.StatusBadGateway,
+		errors.New("unexpected"):                                                                              http.StatusInternalServerError,
+	}
+	for err, expected := range tests {
+		if status := ErrorStatus(err); status != expected {
+			t.Logf("Expecting status %v for %v but found %v.", expected, err, status)
+			t.Fail()
+		}
+	}
+}
+
+func TestParseProviderError(t *testing.T) {
+	bodies := []string{
+		`{"error":"invalid_client","error_description":"Bad secret."}`,
+		`error=invalid_client&error_description=Bad+secret.`,
+	}
+	for _, body := range bodies {
+		providerErr := parseProviderError([]byte(body))
+		if providerErr == nil || providerErr.Code != "invalid_client" || providerErr.Error() != "The provider returned the error invalid_client: Bad secret." {
+			t.Logf("Invalid provider error %v for %v.", providerErr, body)
+			t.Fail()
+		}
+	}
+	if providerErr := parseProviderError([]byte("<html></html>")); providerErr != nil {
+		t.Logf("Expecting no provider error but found %v.", providerErr)
+		t.Fail()
+	}
+}
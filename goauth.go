@@ -67,12 +67,20 @@
 package goauth
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
-	"reflect"
 	"strconv"
 )
 
+// the largest response accepted from a provider.
+const maxResponseSize = 1 << 20
+
 // OAuth 1.0 authentication transmission types.
 const (
 	OAuth1HeaderTransmissionType  = 1 << iota
@@ -84,6 +92,8 @@ const (
 const (
 	OAuthVerbGet     = "GET"
 	OAuthVerbPost    = "POST"
+	OAuthVerbPut     = "PUT"
+	OAuthVerbDelete  = "DELETE"
 	OAuthVerbDefault = OAuthVerbPost
 )
 
@@ -99,6 +109,7 @@ const (
 type UserData struct {
 	UserID         string
 	Email          string
+	EmailVerified  bool
 	FullName       string
 	GivenName      string
 	FamilyName     string
@@ -108,6 +119,22 @@ type UserData struct {
 	OAuthVersion   string
 	OAuthToken     string
 	OAuthTokenType string
+
+	// Token is the full set of credentials issued by the provider.
+	Token *Token
+
+	// Emails are all of the user's email addresses known to the provider,
+	// including the Email.
+	Emails []EmailAddress
+
+	// Raw is the decoded user information, including the attributes which are
+	// not mapped to a field. Use the Claim methods to read from it.
+	Raw map[string]interface{}
+
+	// RawJSON is the response to the UserInfoURL (or the claims of the id token)
+	// as JSON, as it was sent by the provider when the provider sent JSON. It is
+	// not serialized, to keep sessions small; sessions rebuild it from Raw.
+	RawJSON json.RawMessage `json:"-"`
 }
 
 // OAuthServiceProvider is the base class for this library. This is where all
@@ -121,11 +148,24 @@ type OAuthServiceProvider interface {
 	// redirected to Facebook's authentication page.
 	GetRedirectURL() (string, error)
 
+	// GetRedirectURLContext is the same as GetRedirectURL, but any outbound requests
+	// made to the provider use the given context.
+	GetRedirectURLContext(ctx context.Context) (string, error)
+
 	// ProcessResponse is called after the user has been successfully authenticated.
 	// This method will receive a message back from the OAuth provider containing
 	// information about the now authenticated user.
 	ProcessResponse(requet *http.Request) (UserData, error)
 
+	// ProcessResponseContext is the same as ProcessResponse, but any outbound requests
+	// made to the provider use the given context rather than the request's context.
+	ProcessResponseContext(ctx context.Context, request *http.Request) (UserData, error)
+
+	// Client returns an HTTP client which authenticates every request it sends using
+	// a token returned by ProcessResponse, allowing further calls to the provider's
+	// API on behalf of the user. The token can be rehydrated from storage.
+	Client(ctx context.Context, token *Token) (*http.Client, error)
+
 	// GetOAuthVersion gets the version of OAuth implemented by this provider.
 	GetOAuthVersion() string
 
@@ -138,6 +178,7 @@ func (u UserData) String() string {
 	return fmt.Sprintf(`UserData {
 	UserID:         %v,
 	Email:          %v,
+	EmailVerified:  %v,
 	FullName:       %v,
 	GivenName:      %v,
 	FamilyName:     %v,
@@ -147,62 +188,14 @@ func (u UserData) String() string {
 	OAuthVersion:   %v,
 	OAuthToken:     %v,
 	OAuthTokenType: %v
-}`, u.UserID, u.Email, u.FullName, u.GivenName, u.FamilyName, u.ScreenName,
-		u.PhotoURL, u.OAuthProvider, u.OAuthVersion, u.OAuthToken, u.OAuthTokenType)
-}
-
-func toUserData(data map[string]interface{}) UserData {
-	user := UserData{UserID: toStringValue(data["id"])}
-	if name, found := data["name"]; found {
-		user.FullName = name.(string)
-	}
-	if screenName, found := data["screen_name"]; found {
-		user.ScreenName = screenName.(string)
-	}
-	if givenName, found := data["given_name"]; found {
-		user.GivenName = givenName.(string)
-	} else if givenName, found = data["first_name"]; found {
-		user.GivenName = givenName.(string)
-	}
-	if familyName, found := data["family_name"]; found {
-		user.FamilyName = familyName.(string)
-	} else if familyName, found = data["last_name"]; found {
-		user.FamilyName = familyName.(string)
-	}
-	if email, found := data["email"]; found {
-		user.Email = email.(string)
-	}
-	if picture, found := data["picture"]; found {
-		if reflect.TypeOf(picture).Kind() == reflect.Map {
-			m, found := picture.(map[string]interface{})["data"]
-			if found && reflect.TypeOf(m).Kind() == reflect.Map {
-				if u, found := m.(map[string]interface{})["url"]; found {
-					user.PhotoURL = u.(string)
-				}
-			}
-		} else {
-			user.PhotoURL = picture.(string)
-		}
-	} else if picture, found = data["profile_image_url"]; found {
-		user.PhotoURL = picture.(string)
-	}
-	if len(user.FullName) == 0 {
-		if len(user.FamilyName) > 0 {
-			user.FullName = fmt.Sprintf("%v %v", user.GivenName, user.FamilyName)
-		}
-	}
-	if len(user.ScreenName) == 0 {
-		if len(user.FullName) > 0 {
-			user.ScreenName = user.FullName
-		} else if len(user.FamilyName) > 0 {
-			user.ScreenName = fmt.Sprintf("%v %v", user.GivenName, user.FamilyName)
-		}
-	}
-	return user
+}`, u.UserID, u.Email, u.EmailVerified, u.FullName, u.GivenName, u.FamilyName,
+		u.ScreenName, u.PhotoURL, u.OAuthProvider, u.OAuthVersion, u.OAuthToken, u.OAuthTokenType)
 }
 
 func toStringValue(n interface{}) string {
 	switch n.(type) {
+	case nil:
+		return ""
 	default:
 		return fmt.Sprintf("%v", n)
 	case float64:
@@ -217,3 +210,34 @@ func toStringValue(n interface{}) string {
 		return strconv.Itoa(n.(int))
 	}
 }
+
+// readResponseBody reads the body of a provider's response, returning
+// ErrResponseTooLarge rather than reading more than maxResponseSize bytes.
+func readResponseBody(body io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxResponseSize {
+		return nil, ErrResponseTooLarge
+	}
+	return data, nil
+}
+
+// randomString creates a URL safe string from n cryptographically random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
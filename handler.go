@@ -0,0 +1,184 @@
+package goauth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	handlerLoginPath    = "login/"
+	handlerCallbackPath = "callback/"
+	handlerLogoutPath   = "logout"
+)
+
+// SuccessFunc is called by the Handler once a user has been authenticated.
+type SuccessFunc func(w http.ResponseWriter, r *http.Request, user UserData)
+
+// FailureFunc is called by the Handler when a user could not be authenticated.
+type FailureFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// HandlerConfig is a simple struct which can be used to initialize a Handler.
+type HandlerConfig struct {
+
+	// PathPrefix is the path the handler is mounted under (eg: /oauth/).
+	// Defaults to "/".
+	PathPrefix string
+
+	// OnSuccess is called once the user has been authenticated. This is where the
+	// user's session is usually created. Defaults to redirecting back to the page
+	// the RequireAuth middleware sent the user from, or to "/".
+	OnSuccess SuccessFunc
+
+	// OnFailure is called when the user could not be authenticated. Defaults to
+	// responding with an error status chosen using ErrorStatus. The default response
+	// only contains the status text, since the error may describe the provider's
+	// responses or the server's configuration; log the error in a custom OnFailure.
+	OnFailure FailureFunc
+
+	// Sessions is an optional session store. When set, a session is created for the
+	// user before OnSuccess is called and a POST to [PathPrefix]logout ends the
+	// session.
+	Sessions SessionStore
+}
+
+// Handler is an http.Handler which serves the login and callback routes for
+// a set of providers, usually the map returned by ConfigureProvidersFromJSON or
+// ConfigureProvidersFromYAML:
+//
+//	[PathPrefix]login/[provider_name]     redirects the user to the provider
+//	[PathPrefix]callback/[provider_name]  processes the provider's response
+//	[PathPrefix]logout                    ends the session on a POST, if sessions are configured
+type Handler struct {
+	providers map[string]OAuthServiceProvider
+	config    HandlerConfig
+}
+
+// NewHandler creates a Handler for the providers, which are keyed by their
+// lower case provider name.
+func NewHandler(providers map[string]OAuthServiceProvider, config HandlerConfig) *Handler {
+	if len(config.PathPrefix) == 0 {
+		config.PathPrefix = "/"
+	}
+	if !strings.HasSuffix(config.PathPrefix, "/") {
+		config.PathPrefix = config.PathPrefix + "/"
+	}
+	if config.OnSuccess == nil {
+		config.OnSuccess = func(w http.ResponseWriter, r *http.Request, user UserData) {
+			RedirectToReturnURL(w, r, "/")
+		}
+	}
+	if config.OnFailure == nil {
+		config.OnFailure = func(w http.ResponseWriter, r *http.Request, err error) {
+			status := ErrorStatus(err)
+			http.Error(w, http.StatusText(status), status)
+		}
+	}
+	return &Handler{providers: providers, config: config}
+}
+
+// CallbackURLPattern returns the callback URL pattern to configure the providers
+// with when the handler is served from baseURL (eg: http://myserver.com). The
+// pattern can be passed to ConfigureProvidersFromJSON or ConfigureProvidersFromYAML.
+func (h *Handler) CallbackURLPattern(baseURL string) string {
+	return CallbackURLPattern(baseURL, h.config.PathPrefix)
+}
+
+// CallbackURLPattern returns the callback URL pattern for a Handler mounted at
+// pathPrefix and served from baseURL
+// (eg: http://myserver.com/oauth/callback/%v).
+func CallbackURLPattern(baseURL, pathPrefix string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	if pathPrefix = strings.Trim(pathPrefix, "/"); len(pathPrefix) > 0 {
+		pathPrefix = "/" + pathPrefix
+	}
+	pathPrefix = pathPrefix + "/"
+	return strings.Replace(baseURL+pathPrefix, "%", "%%", -1) + handlerCallbackPath + "%v"
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, h.config.PathPrefix)
+	if len(path) == len(r.URL.Path) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case path == handlerLogoutPath && h.config.Sessions != nil:
+		// a GET would let any cross site link end the user's session
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		LogoutHandler(h.config.Sessions, "/").ServeHTTP(w, r)
+		return
+	case strings.HasPrefix(path, handlerLoginPath):
+		if provider, found := h.provider(strings.TrimPrefix(path, handlerLoginPath)); found {
+			h.login(w, r, provider)
+			return
+		}
+	case strings.HasPrefix(path, handlerCallbackPath):
+		if provider, found := h.provider(strings.TrimPrefix(path, handlerCallbackPath)); found {
+			h.callback(w, r, provider)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func (h *Handler) provider(name string) (OAuthServiceProvider, bool) {
+	provider, found := h.providers[strings.ToLower(name)]
+	return provider, found
+}
+
+func (h *Handler) login(w http.ResponseWriter, r *http.Request, provider OAuthServiceProvider) {
+	ctx, err := BindState(w, r)
+	if err != nil {
+		h.config.OnFailure(w, r, err)
+		return
+	}
+	redirectURL, err := provider.GetRedirectURLContext(ctx)
+	if err != nil {
+		h.config.OnFailure(w, r, err)
+		return
+	}
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request, provider OAuthServiceProvider) {
+	user, err := provider.ProcessResponse(r)
+	if err != nil {
+		h.config.OnFailure(w, r, err)
+		return
+	}
+	if h.config.Sessions != nil {
+		if err = h.config.Sessions.Save(w, r, user); err != nil {
+			h.config.OnFailure(w, r, err)
+			return
+		}
+	}
+	h.config.OnSuccess(w, r, user)
+}
+
+// callbackURL fills in the provider name in the callback URL pattern.
+func callbackURL(pattern, providerName string) string {
+	return fmt.Sprintf(pattern, providerName)
+}
+
+// ErrorStatus returns the HTTP status best describing an authentication failure:
+// 403 when the user denied access, 400 for invalid or expired requests, 502 when
+// the provider failed and 500 otherwise.
+func ErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrAccessDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrStateInvalid), errors.Is(err, ErrStateExpired):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrAuthRequest), errors.Is(err, ErrTokenExchange), errors.Is(err, ErrUserInfo):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,137 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testProvider is a stub OAuthServiceProvider which authenticates any request
+// with a code parameter.
+type testProvider struct {
+	name string
+}
+
+func (p *testProvider) GetRedirectURL() (string, error) {
+	return p.GetRedirectURLContext(context.Background())
+}
+
+func (p *testProvider) GetRedirectURLContext(ctx context.Context) (string, error) {
+	return "https://provider.example.com/auth?client_id=" + p.name, nil
+}
+
+func (p *testProvider) ProcessResponse(request *http.Request) (UserData, error) {
+	return p.ProcessResponseContext(request.Context(), request)
+}
+
+func (p *testProvider) ProcessResponseContext(ctx context.Context, request *http.Request) (UserData, error) {
+	if code := request.FormValue(oauth2Code); len(code) > 0 {
+		return UserData{UserID: code, Email: code + "@example.com", EmailVerified: true, OAuthProvider: p.name}, nil
+	}
+	return UserData{}, errors.New("No code.")
+}
+
+func (p *testProvider) Client(ctx context.Context, token *Token) (*http.Client, error) {
+	return http.DefaultClient, nil
+}
+
+func (p *testProvider) GetOAuthVersion() string {
+	return OAuthVersion2
+}
+
+func (p *testProvider) GetProviderName() string {
+	return p.name
+}
+
+func TestHandler(t *testing.T) {
+	var user UserData
+	var failure error
+	handler := NewHandler(map[string]OAuthServiceProvider{"test": &testProvider{name: "TEST"}}, HandlerConfig{
+		PathPrefix: "/oauth",
+		OnSuccess: func(w http.ResponseWriter, r *http.Request, u UserData) {
+			user = u
+		},
+		OnFailure: func(w http.ResponseWriter, r *http.Request, err error) {
+			failure = err
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/oauth/login/test", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://provider.example.com/auth?client_id=TEST" {
+		t.Logf("Invalid login response %v %v.", rec.Code, rec.Header().Get("Location"))
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/oauth/callback/TEST?code=12345", nil))
+	if user.UserID != "12345" || user.OAuthProvider != "TEST" {
+		t.Logf("Invalid user %v.", user)
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/oauth/callback/test", nil))
+	if rec.Code != http.StatusUnauthorized || failure == nil {
+		t.Logf("Expecting failure but found %v.", rec.Code)
+		t.Fail()
+	}
+
+	for _, path := range []string{"/oauth/login/other", "/oauth/other/test", "/login/test"} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Logf("Expecting %v to be not found but was %v.", path, rec.Code)
+			t.Fail()
+		}
+	}
+}
+
+func TestHandlerDefaultFailure(t *testing.T) {
+	handler := NewHandler(map[string]OAuthServiceProvider{"test": &testProvider{name: "TEST"}}, HandlerConfig{})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/callback/test", nil))
+	if rec.Code != http.StatusInternalServerError || strings.TrimSpace(rec.Body.String()) != http.StatusText(http.StatusInternalServerError) {
+		t.Logf("Expecting only the status text but found %v %v.", rec.Code, rec.Body.String())
+		t.Fail()
+	}
+}
+
+func TestCallbackURLPattern(t *testing.T) {
+	handler := NewHandler(nil, HandlerConfig{PathPrefix: "/oauth/"})
+	pattern := handler.CallbackURLPattern("http://myserver.com/")
+	if pattern != "http://myserver.com/oauth/callback/%v" {
+		t.Logf("Invalid pattern %v.", pattern)
+		t.Fail()
+	}
+	if url := callbackURL(CallbackURLPattern("http://myserver.com", ""), "google"); url != "http://myserver.com/callback/google" {
+		t.Logf("Invalid callback url %v.", url)
+		t.Fail()
+	}
+}
+
+func ExampleHandler() {
+	file, _ := os.Open("providers.json")
+	defer file.Close()
+
+	providers, err := ConfigureProvidersFromJSON(file, CallbackURLPattern("http://myserver.com", "/oauth/"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.Handle("/oauth/", NewHandler(providers, HandlerConfig{
+		PathPrefix: "/oauth/",
+		OnSuccess: func(w http.ResponseWriter, r *http.Request, user UserData) {
+			log.Printf("Found user %v", user.String())
+			// create a user session
+			http.Redirect(w, r, "/homepage", http.StatusFound)
+		},
+	}))
+	http.ListenAndServe(":9000", nil)
+}
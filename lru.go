@@ -7,13 +7,16 @@ import (
 	"time"
 )
 
-// this is an LRU cache.
+// this is an LRU cache whose items expire. Once the capacity is reached the
+// expired items are removed, or the least recently used item when none has
+// expired. A cache without a capacity only removes expired items.
 type tokenCache struct {
-	remCapacity    int
-	minTimeInCache int
-	items          map[string]*tokenCacheItem
-	list           *list.List
-	mutex          *sync.Mutex
+	capacity   int
+	timeToLive time.Duration
+	items      map[string]*tokenCacheItem
+	list       *list.List
+	lastSweep  time.Time
+	mutex      *sync.Mutex
 }
 
 type tokenCacheItem struct {
@@ -22,87 +25,152 @@ type tokenCacheItem struct {
 	listElement *list.Element
 }
 
-func newTokenCache(capacity, minTimeInCacheSeconds int) *tokenCache {
+func newTokenCache(capacity, timeToLiveSeconds int) *tokenCache {
 	return &tokenCache{
-		remCapacity:    capacity,
-		minTimeInCache: minTimeInCacheSeconds,
-		items:          make(map[string]*tokenCacheItem, capacity),
-		list:           list.New(),
-		mutex:          &sync.Mutex{},
+		capacity:   capacity,
+		timeToLive: time.Duration(timeToLiveSeconds) * time.Second,
+		items:      make(map[string]*tokenCacheItem),
+		list:       list.New(),
+		lastSweep:  time.Now(),
+		mutex:      &sync.Mutex{},
 	}
 }
 
 func (c *tokenCache) size() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 	return len(c.items)
 }
 
 func (c *tokenCache) getToken(tok string) (token, error) {
 	c.mutex.Lock()
-	item, found := c.items[tok]
-	c.mutex.Unlock()
-
-	if !found {
-		return token{}, fmt.Errorf("Could not find token for %v.", tok)
+	defer c.mutex.Unlock()
+	item, err := c.lookup(tok)
+	if err != nil {
+		return token{}, err
 	}
-	c.promote(item)
+	c.list.MoveToFront(item.listElement)
 	return item.tok, nil
 }
 
-func (c *tokenCache) addToken(oauthToken token) bool {
+// addToken stores the token, replacing the token with the same key.
+func (c *tokenCache) addToken(oauthToken token) {
 	c.mutex.Lock()
-	if c.remCapacity <= 0 {
-		if !c.trimCache() {
-			c.mutex.Unlock()
-			return false
-		}
-	}
+	defer c.mutex.Unlock()
+	c.remove(oauthToken.token)
+	c.insert(oauthToken)
+}
+
+func (c *tokenCache) removeToken(tok string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.remove(tok)
+}
 
-	item, found := c.items[oauthToken.token]
-	c.mutex.Unlock()
+// Put implements the StateStore interface.
+func (c *tokenCache) Put(key, value string) error {
+	c.addToken(token{token: key, secret: value})
+	return nil
+}
 
-	if found {
-		item.tok = oauthToken
-		c.promote(item)
-	} else {
-		item := &tokenCacheItem{
-			tok:    oauthToken,
-			timeIn: time.Now(),
-		}
-		c.mutex.Lock()
-		item.listElement = c.list.PushFront(item)
-		c.items[oauthToken.token] = item
-		c.remCapacity--
-		c.mutex.Unlock()
+// Get implements the StateStore interface.
+func (c *tokenCache) Get(key string) (string, error) {
+	tok, err := c.getToken(key)
+	return tok.secret, err
+}
+
+// Delete implements the StateStore interface.
+func (c *tokenCache) Delete(key string) error {
+	c.removeToken(key)
+	return nil
+}
+
+// Add implements the AtomicStateStore interface.
+func (c *tokenCache) Add(key, value string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, err := c.lookup(key); err == nil {
+		return false, nil
 	}
-	return true
+	c.insert(token{token: key, secret: value})
+	return true, nil
 }
 
-func (c *tokenCache) promote(item *tokenCacheItem) {
+// Take implements the AtomicStateStore interface.
+func (c *tokenCache) Take(key string) (string, error) {
 	c.mutex.Lock()
-	item.timeIn = time.Now()
-	c.list.MoveToFront(item.listElement)
-	c.mutex.Unlock()
+	defer c.mutex.Unlock()
+	item, err := c.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	c.remove(key)
+	return item.tok.secret, nil
 }
 
-func (c *tokenCache) trimCache() bool {
-	success := false
-	// trim 50 oldest items from cache
-	for i := 0; i < 50; i++ {
-		tail := c.list.Back()
-		if tail == nil {
-			return success
+// lookup finds an item which has not expired, removing it if it has.
+func (c *tokenCache) lookup(tok string) (*tokenCacheItem, error) {
+	item, found := c.items[tok]
+	if !found {
+		return nil, fmt.Errorf("Could not find token for %v.", tok)
+	}
+	if c.expired(item) {
+		c.remove(tok)
+		return nil, fmt.Errorf("The token for %v has expired.", tok)
+	}
+	return item, nil
+}
+
+func (c *tokenCache) insert(oauthToken token) {
+	if time.Since(c.lastSweep) >= c.timeToLive {
+		c.sweep()
+	}
+	if c.capacity > 0 && len(c.items) >= c.capacity {
+		c.trimCache()
+	}
+	item := &tokenCacheItem{
+		tok:    oauthToken,
+		timeIn: time.Now(),
+	}
+	item.listElement = c.list.PushFront(item)
+	c.items[oauthToken.token] = item
+}
+
+func (c *tokenCache) remove(tok string) {
+	if item, found := c.items[tok]; found {
+		c.list.Remove(item.listElement)
+		delete(c.items, tok)
+	}
+}
+
+func (c *tokenCache) expired(item *tokenCacheItem) bool {
+	return time.Since(item.timeIn) >= c.timeToLive
+}
+
+// sweep removes every expired item.
+func (c *tokenCache) sweep() {
+	c.lastSweep = time.Now()
+	for element := c.list.Back(); element != nil; {
+		item := element.Value.(*tokenCacheItem)
+		element = element.Prev()
+		if c.expired(item) {
+			c.remove(item.tok.token)
 		}
-		item := c.list.Remove(tail).(*tokenCacheItem)
-		delete(c.items, item.tok.token)
-		success = true
-		c.remCapacity++
-		timeSinceInsert := time.Now().Sub(item.timeIn).Seconds()
-		if timeSinceInsert < float64(c.minTimeInCache) {
-			// the oldest items aren't that old, keep them around a bit longer
-			return success
+	}
+}
+
+// trimCache makes room for a new item, removing the expired items at the end
+// of the list or else the least recently used item.
+func (c *tokenCache) trimCache() {
+	removed := false
+	for tail := c.list.Back(); tail != nil; tail = c.list.Back() {
+		item := tail.Value.(*tokenCacheItem)
+		if removed && !c.expired(item) {
+			return
 		}
+		c.remove(item.tok.token)
+		removed = true
 	}
-	return success
 }
 
 // really just used for testing, not for application code
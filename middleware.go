@@ -0,0 +1,177 @@
+package goauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const returnURLCookieName = "goauth_return_to"
+
+type userContextKey struct{}
+
+// AuthConfig is a simple struct which can be used to initialize the
+// RequireAuth middleware.
+type AuthConfig struct {
+
+	// Sessions is the session store holding the authenticated users.
+	Sessions SessionStore
+
+	// Provider is the provider browsers are sent to when they are not authenticated,
+	// through the login route of the Handler serving it.
+	Provider OAuthServiceProvider
+
+	// PathPrefix is the path the Handler serving the Provider is mounted under (eg:
+	// /oauth/). Defaults to "/".
+	PathPrefix string
+
+	// LoginURL is the page browsers are sent to when they are not authenticated and
+	// no Provider is set, usually a page allowing the user to choose a provider.
+	LoginURL string
+
+	// AllowedEmailDomains restricts access to users with a verified email address in
+	// one of the domains (eg: example.com). Unverified addresses are ignored, since
+	// anyone can add one to an account at most providers.
+	AllowedEmailDomains []string
+
+	// AllowedUserIDs restricts access to the listed users. Entries are a provider
+	// name and user id (eg: GOOGLE:1234567890); user ids are only unique at one
+	// provider, so entries without a provider name never match.
+	AllowedUserIDs []string
+}
+
+// RequireAuth returns middleware which only lets authenticated users through to
+// the wrapped handler. The user is available to the handler through
+// UserFromContext.
+//
+// Unauthenticated browsers are redirected to the Handler's login route for the
+// Provider or to the LoginURL, and the URL they requested is remembered so that the Handler can send them back
+// once they have logged in. API clients receive a 401 JSON response instead.
+// Users who are not in the allow lists receive a 403 response.
+func RequireAuth(config AuthConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := config.Sessions.Load(r)
+			if err != nil {
+				config.unauthorized(w, r)
+				return
+			}
+			if !config.isAllowed(user) {
+				respondWithError(w, r, http.StatusForbidden, "access_denied", "The user is not allowed to access this resource.")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+		})
+	}
+}
+
+// UserFromContext returns the authenticated user added to the request context by
+// the RequireAuth middleware.
+func UserFromContext(ctx context.Context) (UserData, bool) {
+	user, found := ctx.Value(userContextKey{}).(UserData)
+	return user, found
+}
+
+// RedirectToReturnURL redirects the user back to the URL they requested before
+// RequireAuth sent them to log in, or to fallbackURL if there is none.
+func RedirectToReturnURL(w http.ResponseWriter, r *http.Request, fallbackURL string) {
+	redirectURL := fallbackURL
+	if cookie, err := r.Cookie(returnURLCookieName); err == nil && isLocalURL(cookie.Value) {
+		redirectURL = cookie.Value
+	}
+	http.SetCookie(w, &http.Cookie{Name: returnURLCookieName, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+func (config AuthConfig) unauthorized(w http.ResponseWriter, r *http.Request) {
+	if isAPIRequest(r) {
+		respondWithError(w, r, http.StatusUnauthorized, "unauthorized", "Authentication is required to access this resource.")
+		return
+	}
+	// the login route starts the login, so that requests which are never followed
+	// (eg: for images) do not store state or call the provider
+	loginURL := config.LoginURL
+	if config.Provider != nil {
+		pathPrefix := config.PathPrefix
+		if !strings.HasSuffix(pathPrefix, "/") {
+			pathPrefix = pathPrefix + "/"
+		}
+		loginURL = pathPrefix + handlerLoginPath + strings.ToLower(config.Provider.GetProviderName())
+	}
+	if r.Method == http.MethodGet {
+		http.SetCookie(w, &http.Cookie{
+			Name:     returnURLCookieName,
+			Value:    r.URL.RequestURI(),
+			Path:     "/",
+			MaxAge:   oauth2StateFlagMaxAgeSeconds,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+func (config AuthConfig) isAllowed(user UserData) bool {
+	if len(config.AllowedEmailDomains) == 0 && len(config.AllowedUserIDs) == 0 {
+		return true
+	}
+	for _, email := range verifiedEmails(user) {
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			domain := email[at+1:]
+			for _, allowed := range config.AllowedEmailDomains {
+				if strings.EqualFold(domain, allowed) {
+					return true
+				}
+			}
+		}
+	}
+	if len(user.UserID) == 0 || len(user.OAuthProvider) == 0 {
+		return false
+	}
+	for _, allowed := range config.AllowedUserIDs {
+		if strings.EqualFold(allowed, user.OAuthProvider+":"+user.UserID) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifiedEmails returns the user's email addresses which the provider has
+// verified.
+func verifiedEmails(user UserData) []string {
+	var emails []string
+	if user.EmailVerified && len(user.Email) > 0 {
+		emails = append(emails, user.Email)
+	}
+	for _, email := range user.Emails {
+		if email.Verified {
+			emails = append(emails, email.Address)
+		}
+	}
+	return emails
+}
+
+// isAPIRequest guesses whether the request came from a script rather than a
+// browser which can follow a login redirect.
+func isAPIRequest(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return len(r.Header.Get("Authorization")) > 0 ||
+		r.Header.Get("X-Requested-With") == "XMLHttpRequest" ||
+		(strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html"))
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, code, description string) {
+	if !isAPIRequest(r) {
+		http.Error(w, description, status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": description})
+}
+
+// isLocalURL prevents the return URL from being used as an open redirect.
+func isLocalURL(u string) bool {
+	return strings.HasPrefix(u, "/") && !strings.HasPrefix(u, "//") && !strings.HasPrefix(u, "/\\")
+}
@@ -0,0 +1,147 @@
+package goauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAuth(t *testing.T) {
+	store := NewServerSessionStore(NewMemorySessionBackend(), SessionConfig{})
+	protected := RequireAuth(AuthConfig{
+		Sessions:            store,
+		Provider:            &testProvider{name: "TEST"},
+		AllowedEmailDomains: []string{"Example.com"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		w.Write([]byte(user.UserID))
+	}))
+
+	// browsers are redirected to the provider and the requested url is remembered
+	rec := httptest.NewRecorder()
+	protected.ServeHTTP(rec, httptest.NewRequest("GET", "/private?page=2", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/login/test" {
+		t.Logf("Invalid browser response %v %v.", rec.Code, rec.Header().Get("Location"))
+		t.Fail()
+	}
+	var returnCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == returnURLCookieName {
+			returnCookie = cookie
+		}
+		if cookie.Name == stateCookieName {
+			t.Log("Expecting the login to be started by the login route.")
+			t.Fail()
+		}
+	}
+	if returnCookie == nil || returnCookie.Value != "/private?page=2" {
+		t.Logf("Invalid return cookie %v.", returnCookie)
+		t.FailNow()
+	}
+
+	// api clients receive a json error
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/private", nil)
+	req.Header.Set("Accept", "application/json")
+	protected.ServeHTTP(rec, req)
+	body := make(map[string]string)
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusUnauthorized || body["error"] != "unauthorized" {
+		t.Logf("Invalid api response %v %v.", rec.Code, body)
+		t.Fail()
+	}
+
+	// after logging in the handler sends the user back to the requested url
+	handler := NewHandler(map[string]OAuthServiceProvider{"test": &testProvider{name: "TEST"}}, HandlerConfig{Sessions: store})
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/callback/test?code=12345", nil)
+	req.AddCookie(returnCookie)
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Location") != "/private?page=2" {
+		t.Logf("Expecting to return to the requested url but was sent to %v.", rec.Header().Get("Location"))
+		t.Fail()
+	}
+
+	req = httptest.NewRequest("GET", "/private", nil)
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	rec = httptest.NewRecorder()
+	protected.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "12345" {
+		t.Logf("Expecting the authenticated user to be let through but was %v.", rec.Code)
+		t.Fail()
+	}
+}
+
+func TestRequireAuthPathPrefix(t *testing.T) {
+	protected := RequireAuth(AuthConfig{
+		Sessions:   NewServerSessionStore(NewMemorySessionBackend(), SessionConfig{}),
+		Provider:   &testProvider{name: "TEST"},
+		PathPrefix: "/oauth",
+	})(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	protected.ServeHTTP(rec, httptest.NewRequest("GET", "/private", nil))
+	if rec.Header().Get("Location") != "/oauth/login/test" {
+		t.Logf("Expecting the Handler's login route but was %v.", rec.Header().Get("Location"))
+		t.Fail()
+	}
+}
+
+func TestRequireAuthAllowLists(t *testing.T) {
+	config := AuthConfig{AllowedEmailDomains: []string{"example.com"}, AllowedUserIDs: []string{"GOOGLE:42", "1234"}}
+	users := map[string]bool{
+		"janedoe@example.com": true,
+		"janedoe@EXAMPLE.COM": true,
+		"janedoe@example.org": false,
+		"example.com@evil.io": false,
+	}
+	for email, allowed := range users {
+		if config.isAllowed(UserData{UserID: "1", Email: email, EmailVerified: true, OAuthProvider: "GOOGLE"}) != allowed {
+			t.Logf("Expecting %v to be allowed: %v.", email, allowed)
+			t.Fail()
+		}
+	}
+
+	// only verified addresses are trusted
+	if config.isAllowed(UserData{UserID: "1", Email: "janedoe@example.com", OAuthProvider: "GITHUB"}) {
+		t.Log("Expecting an unverified email address to be denied.")
+		t.Fail()
+	}
+	unverified := UserData{UserID: "1", Email: "janedoe@gmail.com", EmailVerified: true, OAuthProvider: "GITHUB", Emails: []EmailAddress{
+		{Address: "janedoe@gmail.com", Verified: true, Primary: true},
+		{Address: "janedoe@example.com"},
+	}}
+	if config.isAllowed(unverified) {
+		t.Log("Expecting an unverified secondary email address to be denied.")
+		t.Fail()
+	}
+	unverified.Emails[1].Verified = true
+	if !config.isAllowed(unverified) {
+		t.Log("Expecting a verified secondary email address to be allowed.")
+		t.Fail()
+	}
+
+	if !config.isAllowed(UserData{UserID: "42", OAuthProvider: "GOOGLE"}) || config.isAllowed(UserData{UserID: "42", OAuthProvider: "GITHUB"}) {
+		t.Log("Expecting only GOOGLE:42 to be allowed.")
+		t.Fail()
+	}
+	if config.isAllowed(UserData{UserID: "1234", OAuthProvider: "GITHUB"}) {
+		t.Log("Expecting an entry without a provider name not to match.")
+		t.Fail()
+	}
+}
+
+func TestRedirectToReturnURL(t *testing.T) {
+	for value, expected := range map[string]string{"/home": "/home", "//evil.io": "/", "https://evil.io": "/"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: returnURLCookieName, Value: value})
+		RedirectToReturnURL(rec, req, "/")
+		if rec.Header().Get("Location") != expected {
+			t.Logf("Expecting %v to redirect to %v but was %v.", value, expected, rec.Header().Get("Location"))
+			t.Fail()
+		}
+	}
+}
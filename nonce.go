@@ -0,0 +1,34 @@
+package goauth
+
+import "time"
+
+// the number of random bytes in a nonce, encoded as 22 characters.
+const nonceBytes = 16
+
+// NonceGenerator creates the oauth_nonce sent with each OAuth 1.0 request. The
+// default generator uses crypto/rand; replace it to create predictable nonces in
+// tests.
+type NonceGenerator interface {
+	// Nonce returns a value which is never repeated with the same timestamp.
+	Nonce() (string, error)
+}
+
+// Clock provides the current time used for OAuth 1.0 timestamps. The default
+// clock is the system clock; replace it to create predictable timestamps in
+// tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+type randomNonceGenerator struct{}
+
+func (randomNonceGenerator) Nonce() (string, error) {
+	return randomString(nonceBytes)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,62 @@
+package goauth
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fixedNonceGenerator string
+
+func (n fixedNonceGenerator) Nonce() (string, error) {
+	return string(n), nil
+}
+
+type fixedClock int64
+
+func (c fixedClock) Now() time.Time {
+	return time.Unix(int64(c), 0)
+}
+
+func TestRandomNonceGenerator(t *testing.T) {
+	nonces := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		nonce, err := randomNonceGenerator{}.Nonce()
+		if err != nil || len(nonce) != 22 || nonces[nonce] {
+			t.Logf("Invalid or repeated nonce %v (%v).", nonce, err)
+			t.FailNow()
+		}
+		nonces[nonce] = true
+	}
+}
+
+func TestSignRequestGolden(t *testing.T) {
+	// the credentials of the example request of RFC 5849 section 1.2
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ClientID:       "dpf43f3p2l4k3l03",
+		ClientSecret:   "kd94hf93k423kf44",
+		NonceGenerator: fixedNonceGenerator("chapoH"),
+		Clock:          fixedClock(137131202),
+	}).(*OAuth1ServiceProvider)
+
+	req := httptest.NewRequest("GET", "http://photos.example.net/photos?file=vacation.jpg&size=original", nil)
+	if err := provider.SignRequest(req, &Token{AccessToken: "nnch734d00sl2jdk", TokenSecret: "pfkkdhi9sl3r4s00"}); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	expected := `OAuth oauth_consumer_key="dpf43f3p2l4k3l03", oauth_nonce="chapoH", oauth_signature="1IAE9RzK%2BDqSqVTdQ%2F0zWANXVzs%3D", oauth_signature_method="HMAC-SHA1", oauth_timestamp="137131202", oauth_token="nnch734d00sl2jdk", oauth_version="1.0"`
+	if header := req.Header.Get(oauthAuthorization); header != expected {
+		t.Logf("Invalid authorization header %v.", header)
+		t.Fail()
+	}
+
+	// the verifier accepts the request at the same time
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{
+		Credentials: testCredentials{"dpf43f3p2l4k3l03": "kd94hf93k423kf44", "dpf43f3p2l4k3l03:nnch734d00sl2jdk": "pfkkdhi9sl3r4s00"},
+		Clock:       fixedClock(137131262),
+	})
+	if _, err := verifier.Verify(req); err != nil {
+		t.Logf("Expecting the request to be verified: %v.", err)
+		t.Fail()
+	}
+}
@@ -1,17 +1,13 @@
 package goauth
 
 import (
-	"bytes"
-	"crypto/hmac"
-	"crypto/sha1"
-	"encoding/base64"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,9 +25,15 @@ const (
 	oauthToken           = "oauth_token"
 	oauthSecretToken     = "oauth_token_secret"
 	oauthVerifier        = "oauth_verifier"
-)
+	oauthBodyHash        = "oauth_body_hash"
+
+	oauthCallbackConfirmed = "oauth_callback_confirmed"
+	oauthProblem           = "oauth_problem"
+	oauthProblemAdvice     = "oauth_problem_advice"
+	oauthDenied            = "denied"
 
-var tokenCtx = newTokenCache(1000, 300)
+	oauth1DefaultTimeout = 30 * time.Second
+)
 
 // NewOAuth1ServiceProvider initializes a new OAuth 2.0 service provider.
 func NewOAuth1ServiceProvider(config OAuth1ServiceProviderConfig) OAuthServiceProvider {
@@ -46,8 +48,28 @@ func NewOAuth1ServiceProvider(config OAuth1ServiceProviderConfig) OAuthServicePr
 		config.AuthTransmissionType = OAuth1DefaultTransmissionType
 	}
 
+	if config.StateStore == nil {
+		config.StateStore = NewMemoryStateStore(defaultStateStoreCapacity, defaultStateStoreMaxAgeSeconds)
+	}
+	if config.NonceGenerator == nil {
+		config.NonceGenerator = randomNonceGenerator{}
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: oauth1DefaultTimeout}
+	}
+	config.Claims = config.Claims.withDefaults()
+
+	signer, err := newSigner(config)
+	if err != nil {
+		signer = &failedSigner{name: config.SignatureMethod, err: err}
+	}
+
 	provider := &OAuth1ServiceProvider{
 		config: config,
+		signer: signer,
 	}
 	return provider
 }
@@ -72,8 +94,8 @@ type OAuth1ServiceProviderConfig struct {
 	// TokenURL is the URL that assigns an access token to the user.
 	TokenURL string
 
-	// UserInfoVerb is the verb used to request user information. Usually one of "GET" or
-	// "POST". Defaults to GET.
+	// UserInfoVerb is the verb used to request user information. One of "GET", "POST",
+	// "PUT" or "DELETE". Defaults to GET.
 	UserInfoVerb string
 
 	// UserInfoURL is the URL to fetch user data from, once the user is authenticated.
@@ -95,12 +117,51 @@ type OAuth1ServiceProviderConfig struct {
 	// Often this URL is also provider specific
 	// (eg: http://myserver.com/oauth/callback/[provider_name]).
 	RedirectURL string
+
+	// StateStore holds the request tokens between the redirect and the callback.
+	// Defaults to an in memory store created for the provider.
+	StateStore StateStore
+
+	// SignatureMethod is the method used to sign requests, one of "HMAC-SHA1",
+	// "HMAC-SHA256", "RSA-SHA1" or "PLAINTEXT". Defaults to HMAC-SHA1.
+	SignatureMethod string
+
+	// PrivateKey is the PEM encoded RSA private key used by the RSA-SHA1 signature
+	// method.
+	PrivateKey string
+
+	// PrivateKeyFile is the path of a file containing the PEM encoded RSA private key,
+	// used when PrivateKey is not set.
+	PrivateKeyFile string
+
+	// Signer signs requests using a custom signature method, overriding the
+	// SignatureMethod.
+	Signer Signer
+
+	// NonceGenerator creates the nonce of each request. Defaults to random nonces.
+	NonceGenerator NonceGenerator
+
+	// Clock provides the timestamp of each request. Defaults to the system clock.
+	Clock Clock
+
+	// HTTPClient sends the requests to the provider, and is the base of the clients
+	// returned by Client. Defaults to a client with a 30 second timeout.
+	HTTPClient *http.Client
+
+	// Claims maps the user information to the UserData fields, for providers which
+	// do not use the common names.
+	Claims ClaimMapping
+
+	// UserInfoRequests are sent after the UserInfoURL, using the UserInfoVerb, for
+	// providers which send the user's email addresses separately.
+	UserInfoRequests []UserInfoRequest
 }
 
 // OAuth1ServiceProvider is an implementation of the OAuthServiceProvider
 // interface for use in OAuth Version 1.0 authentication.
 type OAuth1ServiceProvider struct {
 	config OAuth1ServiceProviderConfig
+	signer Signer
 }
 
 type token struct {
@@ -119,35 +180,69 @@ type oauthPair struct {
 // attempting to authenticate via Facebook's API, the user would need to be
 // redirected to Facebook's authentication page.
 func (provider *OAuth1ServiceProvider) GetRedirectURL() (string, error) {
+	return provider.GetRedirectURLContext(context.Background())
+}
+
+// GetRedirectURLContext is the same as GetRedirectURL, using the context to fetch
+// the request token.
+func (provider *OAuth1ServiceProvider) GetRedirectURLContext(ctx context.Context) (string, error) {
 	var url string
-	token, err := provider.fetchOAuthRequestToken()
-	if err == nil {
-		tokenCtx.addToken(token)
-		url = fmt.Sprintf("%v?%v=%v", provider.config.AuthURL, oauthToken, token.token)
+	token, err := provider.fetchOAuthRequestToken(ctx)
+	if err != nil {
+		return url, wrapError(ErrAuthRequest, "Could not fetch the request token: %w", err)
+	}
+	if err = provider.config.StateStore.Put(stateKeyOAuth1Token+token.token, token.secret); err != nil {
+		return "", err
 	}
-	return url, err
+	url = fmt.Sprintf("%v?%v=%v", provider.config.AuthURL, oauthToken, token.token)
+	return url, nil
 }
 
 // ProcessResponse is called after the user has been successfully authenticated.
 // This method will receive a message back from the OAuth provider containing
 // information about the now authenticated user.
 func (provider *OAuth1ServiceProvider) ProcessResponse(request *http.Request) (UserData, error) {
+	return provider.ProcessResponseContext(request.Context(), request)
+}
+
+// ProcessResponseContext is the same as ProcessResponse, using the context to fetch
+// the access token and the user info.
+func (provider *OAuth1ServiceProvider) ProcessResponseContext(ctx context.Context, request *http.Request) (UserData, error) {
 	var user UserData
 	tokenString := request.FormValue(oauthToken)
 	verifier := request.FormValue(oauthVerifier)
+	if denied := request.FormValue(oauthDenied); len(denied) > 0 {
+		provider.config.StateStore.Delete(stateKeyOAuth1Token + denied)
+		return user, ErrAccessDenied
+	}
 	if len(tokenString) > 0 && len(verifier) > 0 {
-		if token, err := tokenCtx.getToken(tokenString); err == nil {
-			accessToken, err := provider.fetchOAuthAccessToken(token, verifier)
+		if secret, err := takeState(provider.config.StateStore, stateKeyOAuth1Token+tokenString); err == nil {
+			token := token{token: tokenString, secret: secret}
+			accessToken, err := provider.fetchOAuthAccessToken(ctx, token, verifier)
 			if err != nil {
-				return user, err
+				return user, wrapError(ErrTokenExchange, "Could not fetch the access token: %w", err)
 			}
 
-			user, err := provider.fetchUserInfo(accessToken, verifier)
-			return user, err
+			user, err := provider.fetchUserInfo(ctx, accessToken)
+			if err != nil {
+				return user, wrapError(ErrUserInfo, "Could not fetch the user information: %w", err)
+			}
+			return user, nil
 		}
-		return user, errors.New("Invalid request: could not validate oauth token.")
+		return user, wrapError(ErrStateInvalid, "Invalid request: could not validate oauth token.")
+	}
+	return user, wrapError(ErrStateInvalid, "Invalid request: missing token or verifier.")
+}
+
+// Client returns an HTTP client which signs every request with the consumer
+// credentials and the token returned by a previous call to ProcessResponse.
+func (provider *OAuth1ServiceProvider) Client(ctx context.Context, token *Token) (*http.Client, error) {
+	if token == nil || len(token.AccessToken) == 0 {
+		return nil, errors.New("No access token to authenticate the client with.")
 	}
-	return user, errors.New("Invalid request: missing token or verifier.")
+	client := *provider.config.HTTPClient
+	client.Transport = &oauth1Transport{provider: provider, token: token, base: client.Transport}
+	return &client, nil
 }
 
 // GetOAuthVersion gets the version of OAuth implemented by this provider.
@@ -160,201 +255,332 @@ func (provider *OAuth1ServiceProvider) GetProviderName() string {
 	return provider.config.ProviderName
 }
 
-func (provider *OAuth1ServiceProvider) fetchOAuthRequestToken() (token, error) {
-	params := provider.generateParams("", "", "")
-
-	baseStringParamOrder := []string{oauthCallback, oauthConsumerKey, oauthNonce, oauthSignatureMethod, oauthTimestamp, oauthVersion}
-	baseString := provider.createBaseString(provider.config.RequestTokenVerb, provider.config.RequestTokenURL, toParamList(params, baseStringParamOrder))
-
-	methodSignature := provider.createMethodSignature(baseString, provider.config.ClientSecret, "")
-	params[oauthSignature] = methodSignature
-
-	var data []byte
-	var err error
-	switch provider.config.AuthTransmissionType {
-	case OAuth1HeaderTransmissionType:
-		headerParamOrder := []string{oauthNonce, oauthSignature, oauthCallback, oauthConsumerKey, oauthTimestamp, oauthSignatureMethod, oauthVersion}
-		header := provider.createHeader(toParamList(params, headerParamOrder))
+func (provider *OAuth1ServiceProvider) fetchOAuthRequestToken(ctx context.Context) (token, error) {
+	params, err := provider.generateParams("")
+	if err != nil {
+		return token{}, err
+	}
+	params[oauthCallback] = provider.config.RedirectURL
 
-		data, err = provider.getResponseByHeader(provider.config.RequestTokenVerb, provider.config.RequestTokenURL, header)
-	case OAuth1QueryParamTramssionType:
-		data, err = provider.getResponseByQuery(provider.config.RequestTokenVerb, provider.config.RequestTokenURL, params)
+	data, err := provider.getSignedResponse(ctx, provider.config.RequestTokenVerb, provider.config.RequestTokenURL, params, "")
+	if err != nil {
+		return token{}, err
 	}
-	if err == nil {
-		if values, err := url.ParseQuery(string(data)); err == nil {
-			tkn := values.Get(oauthToken)
-			secret := values.Get(oauthSecretToken)
-			return token{token: tkn, secret: secret}, nil
-		}
+	values, err := parseTokenResponse(data)
+	if err != nil {
+		return token{}, err
+	}
+	// OAuth 1.0a providers confirm that they will send the user to the callback
+	if values.Get(oauthCallbackConfirmed) != "true" {
+		return token{}, errors.New("The provider did not confirm the callback URL.")
 	}
-	return token{}, err
+	return token{token: values.Get(oauthToken), secret: values.Get(oauthSecretToken)}, nil
 }
 
-func (provider *OAuth1ServiceProvider) fetchOAuthAccessToken(authToken token, verifier string) (token, error) {
-	params := provider.generateParams(authToken.token, authToken.secret, verifier)
-
-	baseStringParamOrder := []string{oauthConsumerKey, oauthNonce, oauthSignatureMethod, oauthTimestamp, oauthToken, oauthVerifier, oauthVersion}
-	baseString := provider.createBaseString(provider.config.RequestTokenVerb, provider.config.TokenURL, toParamList(params, baseStringParamOrder))
+func (provider *OAuth1ServiceProvider) fetchOAuthAccessToken(ctx context.Context, authToken token, verifier string) (*Token, error) {
+	params, err := provider.generateParams(authToken.token)
+	if err != nil {
+		return nil, err
+	}
+	params[oauthVerifier] = verifier
 
-	methodSignature := provider.createMethodSignature(baseString, authToken.token, authToken.secret)
-	params[oauthSignature] = methodSignature
+	data, err := provider.getSignedResponse(ctx, provider.config.RequestTokenVerb, provider.config.TokenURL, params, authToken.secret)
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseTokenResponse(data)
+	if err != nil {
+		return nil, err
+	}
+	return newOAuth1Token(values), nil
+}
 
-	var data []byte
-	var err error
-	switch provider.config.AuthTransmissionType {
-	case OAuth1HeaderTransmissionType:
-		headerParamOrder := []string{oauthVerifier, oauthNonce, oauthSignature, oauthToken, oauthConsumerKey, oauthTimestamp, oauthSignatureMethod, oauthVersion}
-		header := provider.createHeader(toParamList(params, headerParamOrder))
+// parseTokenResponse parses a form encoded token response, which must contain a
+// token unless the provider reported a problem.
+func parseTokenResponse(data []byte) (url.Values, error) {
+	values, err := url.ParseQuery(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse the token response: %v.", err)
+	}
+	if problem := values.Get(oauthProblem); len(problem) > 0 {
+		return nil, &OAuth1ProblemError{StatusCode: http.StatusOK, Problem: problem, Advice: values.Get(oauthProblemAdvice)}
+	}
+	if len(values.Get(oauthToken)) == 0 {
+		return nil, errors.New("The token response did not contain a token.")
+	}
+	return values, nil
+}
 
-		data, err = provider.getResponseByHeader(provider.config.RequestTokenVerb, provider.config.TokenURL, header)
-	case OAuth1QueryParamTramssionType:
-		data, err = provider.getResponseByQuery(provider.config.RequestTokenVerb, provider.config.TokenURL, params)
+func (provider *OAuth1ServiceProvider) fetchUserInfo(ctx context.Context, accessToken *Token) (UserData, error) {
+	var user UserData
+	value, data, err := provider.getUserInfo(ctx, provider.config.UserInfoURL, accessToken)
+	if err != nil {
+		return user, err
 	}
-	if err == nil {
-		if values, err := url.ParseQuery(string(data)); err == nil {
-			accessToken := values.Get(oauthToken)
-			accessSecretToken := values.Get(oauthSecretToken)
-			return token{token: accessToken, secret: accessSecretToken}, nil
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return user, errors.New("The user information is not a JSON object.")
+	}
+	for _, request := range provider.config.UserInfoRequests {
+		value, _, err := provider.getUserInfo(ctx, request.URL, accessToken)
+		if err != nil {
+			return user, err
+		}
+		if err = mergeUserInfo(m, request, value); err != nil {
+			return user, err
 		}
 	}
-	return token{}, err
-}
 
-func (provider *OAuth1ServiceProvider) fetchUserInfo(accessToken token, verifier string) (UserData, error) {
-	params := provider.generateParams(accessToken.token, accessToken.secret, verifier)
+	user = provider.config.Claims.userData(m, data)
+	user.OAuthProvider = strings.ToUpper(provider.config.ProviderName)
+	user.OAuthVersion = OAuthVersion1
+	user.OAuthToken = accessToken.AccessToken
+	user.OAuthTokenType = "Access Token"
+	user.Token = accessToken
 
-	baseStringParamOrder := []string{oauthConsumerKey, oauthNonce, oauthSignatureMethod, oauthTimestamp, oauthToken, oauthVersion}
-	baseString := provider.createBaseString(provider.config.UserInfoVerb, provider.config.UserInfoURL, toParamList(params, baseStringParamOrder))
+	return user, nil
+}
 
-	methodSignature := provider.createMethodSignature(baseString, provider.config.ClientSecret, accessToken.secret)
-	params[oauthSignature] = methodSignature
+// getUserInfo sends a signed request for user information to the URL, decoding
+// the JSON response.
+func (provider *OAuth1ServiceProvider) getUserInfo(ctx context.Context, userInfoURL string, accessToken *Token) (interface{}, []byte, error) {
+	params, err := provider.generateParams(accessToken.AccessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := provider.getSignedResponse(ctx, provider.config.UserInfoVerb, userInfoURL, params, accessToken.TokenSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := decodeUserInfo(data, "application/json")
+	return value, data, err
+}
 
-	var data []byte
-	var err error
-	var user UserData
+// getSignedResponse signs the oauth parameters together with the query of the
+// request URL and sends them using the configured transmission type.
+func (provider *OAuth1ServiceProvider) getSignedResponse(ctx context.Context, verb, requestURL string, params map[string]string, tokenSecret string) ([]byte, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return make([]byte, 0), err
+	}
+	baseString := createBaseString(verb, u, collectParameters(u, nil, params))
+	if params[oauthSignature], err = provider.createMethodSignature(baseString, tokenSecret); err != nil {
+		return make([]byte, 0), err
+	}
 
 	switch provider.config.AuthTransmissionType {
 	case OAuth1HeaderTransmissionType:
-		headerParamOrder := []string{oauthConsumerKey, oauthNonce, oauthSignature, oauthSignatureMethod, oauthTimestamp, oauthToken, oauthVersion}
-		header := provider.createHeader(toParamList(params, headerParamOrder))
-
-		data, err = provider.getResponseByHeader(provider.config.UserInfoVerb, provider.config.UserInfoURL, header)
+		return provider.getResponseByHeader(ctx, verb, requestURL, provider.createHeader(params))
 	case OAuth1QueryParamTramssionType:
-		data, err = provider.getResponseByQuery(provider.config.UserInfoVerb, provider.config.UserInfoURL, params)
-	}
-
-	if err == nil {
-		m := make(map[string]interface{})
-		dec := json.NewDecoder(bytes.NewBuffer(data))
-		err = dec.Decode(&m)
-		if err == nil {
-			user = toUserData(m)
-			user.OAuthProvider = strings.ToUpper(provider.config.ProviderName)
-			user.OAuthVersion = OAuthVersion1
-			user.OAuthToken = accessToken.token
-			user.OAuthTokenType = "Access Token"
-
-			return user, nil
-		}
+		return provider.getResponseByQuery(ctx, verb, requestURL, params)
 	}
-	return user, err
+	return make([]byte, 0), fmt.Errorf("Unsupported transmission type %v.", provider.config.AuthTransmissionType)
 }
 
-func (provider *OAuth1ServiceProvider) getResponseByQuery(verb, requestURL string, params map[string]string) ([]byte, error) {
-	client := &http.Client{}
-
+func (provider *OAuth1ServiceProvider) getResponseByQuery(ctx context.Context, verb, requestURL string, params map[string]string) ([]byte, error) {
 	values := url.Values{}
 	for key, value := range params {
 		values.Add(key, value)
 	}
 
-	var resp *http.Response
+	var req *http.Request
 	var err error
 
 	switch verb {
-	case OAuthVerbGet:
-		resp, err = client.Get(requestURL + "?" + values.Encode())
-	case OAuthVerbPost:
-		resp, err = client.PostForm(requestURL, values)
+	case OAuthVerbGet, OAuthVerbDelete:
+		separator := "?"
+		if strings.Contains(requestURL, "?") {
+			separator = "&"
+		}
+		req, err = http.NewRequestWithContext(ctx, verb, requestURL+separator+values.Encode(), nil)
+	case OAuthVerbPost, OAuthVerbPut:
+		req, err = http.NewRequestWithContext(ctx, verb, requestURL, strings.NewReader(values.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	default:
+		err = fmt.Errorf("Unsupported verb %v.", verb)
 	}
-	defer resp.Body.Close()
-	if err == nil {
-		return ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return make([]byte, 0), err
 	}
-	return make([]byte, 0), err
+	return provider.getResponse(req, requestURL)
 }
 
-func (provider *OAuth1ServiceProvider) getResponseByHeader(verb, url, header string) ([]byte, error) {
-	client := &http.Client{}
-	req, _ := http.NewRequest(verb, url, nil)
+func (provider *OAuth1ServiceProvider) getResponseByHeader(ctx context.Context, verb, url, header string) ([]byte, error) {
+	switch verb {
+	case OAuthVerbGet, OAuthVerbPost, OAuthVerbPut, OAuthVerbDelete:
+	default:
+		return make([]byte, 0), fmt.Errorf("Unsupported verb %v.", verb)
+	}
+	req, err := http.NewRequestWithContext(ctx, verb, url, nil)
+	if err != nil {
+		return make([]byte, 0), err
+	}
 	req.Header.Add(oauthAuthorization, header)
+	return provider.getResponse(req, url)
+}
 
-	resp, err := client.Do(req)
+// getResponse sends the request and reads the response, returning an
+// OAuth1ProblemError if the provider rejected the request and a TransportError if
+// the request could not be completed.
+func (provider *OAuth1ServiceProvider) getResponse(req *http.Request, requestURL string) ([]byte, error) {
+	resp, err := provider.config.HTTPClient.Do(req)
+	if err != nil {
+		return make([]byte, 0), &TransportError{URL: requestURL, Err: err}
+	}
 	defer resp.Body.Close()
-	if err == nil {
-		return ioutil.ReadAll(resp.Body)
+
+	data, err := readResponseBody(resp.Body)
+	if err != nil {
+		return make([]byte, 0), &TransportError{URL: requestURL, Err: err}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		problemErr := &OAuth1ProblemError{StatusCode: resp.StatusCode}
+		if values, err := url.ParseQuery(string(data)); err == nil {
+			problemErr.Problem = values.Get(oauthProblem)
+			problemErr.Advice = values.Get(oauthProblemAdvice)
+		}
+		// some providers report problems in the WWW-Authenticate header instead
+		if len(problemErr.Problem) == 0 {
+			if params, err := parseAuthorizationHeader(strings.TrimPrefix(resp.Header.Get("WWW-Authenticate"), oauthPreamble+" ")); err == nil {
+				problemErr.Problem = params[oauthProblem]
+				problemErr.Advice = params[oauthProblemAdvice]
+			}
+		}
+		return data, problemErr
 	}
-	return make([]byte, 0), err
+	return data, nil
 }
 
-func (provider *OAuth1ServiceProvider) createHeader(params []oauthPair) string {
+// createHeader creates the Authorization header carrying the oauth parameters,
+// as described in RFC 5849 section 3.5.1.
+func (provider *OAuth1ServiceProvider) createHeader(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
 	var header string
-	for _, param := range params {
-		if len(header) == 0 {
-			header = fmt.Sprintf("%v=\"%v\"", param.key, url.QueryEscape(param.value))
-		} else {
-			header = fmt.Sprintf("%v, %v=\"%v\"", header, param.key, url.QueryEscape(param.value))
+	for _, key := range keys {
+		if len(header) > 0 {
+			header = header + ", "
 		}
+		header = fmt.Sprintf("%v%v=\"%v\"", header, percentEncode(key), percentEncode(params[key]))
 	}
 	return oauthPreamble + " " + header
 }
 
-func (provider *OAuth1ServiceProvider) createMethodSignature(baseString, clientSecret, oauthSecret string) string {
-	secretKey := url.QueryEscape(clientSecret) + "&"
-	if len(oauthSecret) > 0 {
-		secretKey = secretKey + url.QueryEscape(oauthSecret)
-	}
-	mac := hmac.New(sha1.New, []byte(secretKey))
-	mac.Write([]byte(baseString))
-	encoded := mac.Sum(nil)
-	return base64.StdEncoding.EncodeToString(encoded)
+func (provider *OAuth1ServiceProvider) createMethodSignature(baseString, oauthSecret string) (string, error) {
+	return provider.signer.Sign(baseString, provider.config.ClientSecret, oauthSecret)
 }
 
-func (provider *OAuth1ServiceProvider) createBaseString(verb, tourl string, params []oauthPair) string {
-	paramString := ""
-	for _, param := range params {
-		if len(paramString) == 0 {
-			paramString = param.key + "=" + url.QueryEscape(param.value)
-		} else {
-			paramString = paramString + "&" + param.key + "=" + url.QueryEscape(param.value)
-		}
+// generateParams creates the oauth parameters sent with every request. The
+// token is omitted when empty, as when fetching the request token.
+func (provider *OAuth1ServiceProvider) generateParams(token string) (map[string]string, error) {
+	nonce, err := provider.config.NonceGenerator.Nonce()
+	if err != nil {
+		return nil, err
 	}
-	return fmt.Sprintf("%v&%v&%v", strings.ToUpper(verb),
-		url.QueryEscape(tourl), url.QueryEscape(paramString))
-}
-
-func (provider *OAuth1ServiceProvider) generateParams(token, secret, verifier string) map[string]string {
 	params := make(map[string]string)
 
-	params[oauthCallback] = provider.config.RedirectURL
 	params[oauthConsumerKey] = provider.config.ClientID
-	params[oauthNonce] = fmt.Sprintf("%v%v", time.Now().Unix(), rand.Intn(100)+rand.Intn(100)*12)
-	params[oauthSignatureMethod] = "HMAC-SHA1"
-	params[oauthTimestamp] = fmt.Sprint(time.Now().Unix())
+	params[oauthNonce] = nonce
+	params[oauthSignatureMethod] = provider.signer.Name()
+	params[oauthTimestamp] = strconv.FormatInt(provider.config.Clock.Now().Unix(), 10)
 	params[oauthVersion] = OAuthVersion1
-	params[oauthToken] = token
-	params[oauthSecretToken] = secret
-	params[oauthVerifier] = verifier
+	if len(token) > 0 {
+		params[oauthToken] = token
+	}
+
+	return params, nil
+}
+
+// createBaseString creates the signature base string described in RFC 5849
+// section 3.4.1 from the request method, the request URL and the collected
+// request parameters.
+func createBaseString(verb string, requestURL *url.URL, params []oauthPair) string {
+	return strings.ToUpper(verb) + "&" + percentEncode(normalizeBaseURI(requestURL)) + "&" + percentEncode(normalizeParameters(params))
+}
 
+// collectParameters collects the parameters covered by the signature: the query
+// of the request URL, the form encoded body and the oauth parameters, excluding
+// the signature itself (RFC 5849 section 3.4.1.3.1).
+func collectParameters(requestURL *url.URL, body url.Values, oauthParams map[string]string) []oauthPair {
+	var params []oauthPair
+	// a malformed query still signs the pairs which could be parsed
+	query, _ := url.ParseQuery(requestURL.RawQuery)
+	for _, values := range []url.Values{query, body} {
+		for key, vals := range values {
+			for _, value := range vals {
+				if key != oauthSignature {
+					params = append(params, oauthPair{key: key, value: value})
+				}
+			}
+		}
+	}
+	for key, value := range oauthParams {
+		if key != oauthSignature {
+			params = append(params, oauthPair{key: key, value: value})
+		}
+	}
 	return params
 }
 
-func toParamList(params map[string]string, order []string) []oauthPair {
-	paramList := make([]oauthPair, 0, len(order))
-	for _, key := range order {
-		if value, found := params[key]; found {
-			paramList = append(paramList, oauthPair{key: key, value: value})
+// normalizeParameters encodes and sorts the parameters by name and value, then
+// joins them as described in RFC 5849 section 3.4.1.3.2.
+func normalizeParameters(params []oauthPair) string {
+	encoded := make([]oauthPair, len(params))
+	for i, param := range params {
+		encoded[i] = oauthPair{key: percentEncode(param.key), value: percentEncode(param.value)}
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		if encoded[i].key == encoded[j].key {
+			return encoded[i].value < encoded[j].value
+		}
+		return encoded[i].key < encoded[j].key
+	})
+
+	pairs := make([]string, len(encoded))
+	for i, param := range encoded {
+		pairs[i] = param.key + "=" + param.value
+	}
+	return strings.Join(pairs, "&")
+}
+
+// normalizeBaseURI returns the base string URI described in RFC 5849 section
+// 3.4.1.2: the scheme and host are lowercase, default ports are removed and the
+// query and fragment are excluded.
+func normalizeBaseURI(requestURL *url.URL) string {
+	scheme := strings.ToLower(requestURL.Scheme)
+	host := strings.ToLower(requestURL.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	port := requestURL.Port()
+	if len(port) > 0 && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host = host + ":" + port
+	}
+	path := requestURL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
+
+// percentEncode encodes a string as described in RFC 5849 section 3.6, leaving
+// only the unreserved characters of RFC 3986 unencoded.
+func percentEncode(s string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
 		}
 	}
-	return paramList
+	return encoded.String()
 }
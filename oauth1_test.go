@@ -0,0 +1,293 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOAuth1GetRedirectURLContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ProviderName:    "test",
+		ClientID:        "CLIENT_ID",
+		ClientSecret:    "CLIENT_SECRET",
+		AuthURL:         server.URL + "/authorize",
+		TokenURL:        server.URL + "/access_token",
+		UserInfoURL:     server.URL + "/userinfo",
+		RequestTokenURL: server.URL + "/request_token",
+		RedirectURL:     "http://myserver.com/oauth/callback/test",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.GetRedirectURLContext(ctx); err == nil {
+		t.Log("Expecting a canceled context to abort the request token fetch.")
+		t.Fail()
+	}
+}
+
+func TestCreateBaseString(t *testing.T) {
+	// the example request of RFC 5849 section 3.4.1
+	u, _ := url.Parse("http://example.com/request?b5=%3D%253D&a3=a&c%40=&a2=r%20b")
+	body, _ := url.ParseQuery("c2&a3=2+q")
+	params := map[string]string{
+		oauthConsumerKey:     "9djdj82h48djs9d2",
+		oauthToken:           "kkk9d7h3k39sjv7",
+		oauthSignatureMethod: "HMAC-SHA1",
+		oauthTimestamp:       "137131201",
+		oauthNonce:           "7d8f3e4a",
+		oauthSignature:       "djosJKDKJSD8743243%2Fjdk33klY%3D",
+	}
+	expected := "POST&http%3A%2F%2Fexample.com%2Frequest&a2%3Dr%2520b%26a3%3D2%2520q%26a3%3Da%26b5%3D%253D%25253D%26c%2540%3D%26c2%3D%26oauth_consumer_key%3D9djdj82h48djs9d2%26oauth_nonce%3D7d8f3e4a%26oauth_signature_method%3DHMAC-SHA1%26oauth_timestamp%3D137131201%26oauth_token%3Dkkk9d7h3k39sjv7"
+	if baseString := createBaseString("POST", u, collectParameters(u, body, params)); baseString != expected {
+		t.Logf("Invalid base string %v.", baseString)
+		t.Fail()
+	}
+}
+
+func TestCreateMethodSignature(t *testing.T) {
+	// the example request of RFC 5849 section 1.2
+	u, _ := url.Parse("http://photos.example.net/photos?file=vacation.jpg&size=original")
+	params := map[string]string{
+		oauthConsumerKey:     "dpf43f3p2l4k3l03",
+		oauthToken:           "nnch734d00sl2jdk",
+		oauthSignatureMethod: "HMAC-SHA1",
+		oauthTimestamp:       "137131202",
+		oauthNonce:           "chapoH",
+	}
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{ClientSecret: "kd94hf93k423kf44"}).(*OAuth1ServiceProvider)
+	baseString := createBaseString("GET", u, collectParameters(u, nil, params))
+	signature, err := provider.createMethodSignature(baseString, "pfkkdhi9sl3r4s00")
+	if err != nil || signature != "MdpQcU8iPSUjWoN/UDMsK2sui9I=" {
+		t.Logf("Invalid signature %v for base string %v.", signature, baseString)
+		t.Fail()
+	}
+}
+
+func TestNormalizeBaseURI(t *testing.T) {
+	tests := map[string]string{
+		"HTTP://EXAMPLE.COM:80/r%20v/X?id=123": "http://example.com/r%20v/X",
+		"https://www.example.net:8080/?q=1":    "https://www.example.net:8080/",
+		"https://Example.com:443":              "https://example.com/",
+		"http://[::1]:8080/a#fragment":         "http://[::1]:8080/a",
+	}
+	for rawURL, expected := range tests {
+		u, _ := url.Parse(rawURL)
+		if normalized := normalizeBaseURI(u); normalized != expected {
+			t.Logf("Expecting %v for %v but found %v.", expected, rawURL, normalized)
+			t.Fail()
+		}
+	}
+}
+
+func TestPercentEncode(t *testing.T) {
+	if encoded := percentEncode("Ladies + Gentlemen*~!é"); encoded != "Ladies%20%2B%20Gentlemen%2A~%21%C3%A9" {
+		t.Logf("Invalid encoding %v.", encoded)
+		t.Fail()
+	}
+}
+
+// newOAuth1TestServer creates a provider which verifies the signature of every
+// request, issuing the request token "reqtoken" and the access token "acctoken".
+func newOAuth1TestServer(t *testing.T, requestTokenResponse string) (*httptest.Server, OAuthServiceProvider) {
+	credentials := testCredentials{"CLIENT_ID": "CLIENT_SECRET", "CLIENT_ID:reqtoken": "reqsecret", "CLIENT_ID:acctoken": "accsecret"}
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: credentials})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/request_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestTokenResponse))
+	})
+	mux.HandleFunc("/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("oauth_token=acctoken&oauth_token_secret=accsecret&user_id=12345"))
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("include_email") == "true" {
+			w.Write([]byte(`{"id":"12345","name":"Other","email":"jane@example.com"}`))
+			return
+		}
+		w.Write([]byte(`{"id":"12345","name":"Jane Doe"}`))
+	})
+	server := httptest.NewServer(verifier.Handler(mux))
+
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ProviderName:    "test",
+		ClientID:        "CLIENT_ID",
+		ClientSecret:    "CLIENT_SECRET",
+		AuthURL:         server.URL + "/authorize",
+		TokenURL:        server.URL + "/access_token",
+		UserInfoURL:     server.URL + "/userinfo",
+		RequestTokenURL: server.URL + "/request_token",
+		RedirectURL:     "http://myserver.com/oauth/callback/test",
+		StateStore:      NewMemoryStateStore(10, 300),
+		UserInfoRequests: []UserInfoRequest{
+			{URL: server.URL + "/userinfo?include_email=true"},
+		},
+	})
+	return server, provider
+}
+
+func TestOAuth1Login(t *testing.T) {
+	server, provider := newOAuth1TestServer(t, "oauth_token=reqtoken&oauth_token_secret=reqsecret&oauth_callback_confirmed=true")
+	defer server.Close()
+
+	redirectURL, err := provider.GetRedirectURL()
+	if err != nil || redirectURL != server.URL+"/authorize?oauth_token=reqtoken" {
+		t.Logf("Invalid redirect URL %v (%v).", redirectURL, err)
+		t.FailNow()
+	}
+	user, err := provider.ProcessResponse(httptest.NewRequest("GET", "/callback?oauth_token=reqtoken&oauth_verifier=abc", nil))
+	if err != nil || user.UserID != "12345" || user.Token.TokenSecret != "accsecret" || user.FullName != "Jane Doe" || user.Email != "jane@example.com" {
+		t.Logf("Invalid user %v (%v).", user, err)
+		t.Fail()
+	}
+}
+
+func TestOAuth1RequestTokenErrors(t *testing.T) {
+	server, provider := newOAuth1TestServer(t, "oauth_token=reqtoken&oauth_token_secret=reqsecret")
+	defer server.Close()
+	if _, err := provider.GetRedirectURL(); !errors.Is(err, ErrAuthRequest) {
+		t.Logf("Expecting an error when the callback is not confirmed but found %v.", err)
+		t.Fail()
+	}
+
+	server, provider = newOAuth1TestServer(t, "oauth_problem=permission_denied&oauth_problem_advice=Application+suspended")
+	defer server.Close()
+	var problemErr *OAuth1ProblemError
+	if _, err := provider.GetRedirectURL(); !errors.As(err, &problemErr) || problemErr.Problem != "permission_denied" || problemErr.Advice != "Application suspended" {
+		t.Logf("Expecting the problem to be reported but found %v.", err)
+		t.Fail()
+	}
+
+	// the provider rejects the signature
+	provider = NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ClientID:        "CLIENT_ID",
+		ClientSecret:    "WRONG_SECRET",
+		RequestTokenURL: server.URL + "/request_token",
+	})
+	if _, err := provider.GetRedirectURL(); !errors.As(err, &problemErr) || problemErr.StatusCode != http.StatusUnauthorized || problemErr.Problem != "signature_invalid" {
+		t.Logf("Expecting the rejected signature to be reported but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestOAuth1AccessDenied(t *testing.T) {
+	server, provider := newOAuth1TestServer(t, "oauth_token=reqtoken&oauth_token_secret=reqsecret&oauth_callback_confirmed=true")
+	defer server.Close()
+	if _, err := provider.GetRedirectURL(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := provider.ProcessResponse(httptest.NewRequest("GET", "/callback?denied=reqtoken", nil)); err != ErrAccessDenied {
+		t.Logf("Expecting access to be denied but found %v.", err)
+		t.Fail()
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestOAuth1HTTPClient(t *testing.T) {
+	server, _ := newOAuth1TestServer(t, "oauth_token=reqtoken&oauth_token_secret=reqsecret&oauth_callback_confirmed=true")
+	defer server.Close()
+
+	var requests []string
+	client := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ClientID:             "CLIENT_ID",
+		ClientSecret:         "CLIENT_SECRET",
+		AuthURL:              server.URL + "/authorize",
+		TokenURL:             server.URL + "/access_token",
+		UserInfoURL:          server.URL + "/userinfo",
+		UserInfoVerb:         OAuthVerbPut,
+		RequestTokenURL:      server.URL + "/request_token",
+		AuthTransmissionType: OAuth1QueryParamTramssionType,
+		HTTPClient:           client,
+	})
+	if _, err := provider.GetRedirectURL(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err := provider.ProcessResponse(httptest.NewRequest("GET", "/callback?oauth_token=reqtoken&oauth_verifier=abc", nil)); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	signedClient, _ := provider.Client(context.Background(), &Token{AccessToken: "acctoken", TokenSecret: "accsecret"})
+	if resp, err := signedClient.Get(server.URL + "/userinfo"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Logf("Expecting the signed request to be accepted (%v).", err)
+		t.Fail()
+	}
+	expected := "POST /request_token,POST /access_token,PUT /userinfo,GET /userinfo"
+	if strings.Join(requests, ",") != expected {
+		t.Logf("Expecting the requests %v but found %v.", expected, requests)
+		t.Fail()
+	}
+}
+
+func TestOAuth1TransportErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Write([]byte(strings.Repeat("a", maxResponseSize+1)))
+	}))
+	defer server.Close()
+
+	config := OAuth1ServiceProviderConfig{
+		ClientID:        "CLIENT_ID",
+		ClientSecret:    "CLIENT_SECRET",
+		RequestTokenURL: server.URL + "/slow",
+		HTTPClient:      &http.Client{Timeout: 10 * time.Millisecond},
+	}
+	var transportErr *TransportError
+	if _, err := NewOAuth1ServiceProvider(config).GetRedirectURL(); !errors.As(err, &transportErr) || transportErr.URL != config.RequestTokenURL {
+		t.Logf("Expecting a transport error but found %v.", err)
+		t.Fail()
+	}
+
+	config.RequestTokenURL = server.URL + "/large"
+	config.HTTPClient = nil
+	if _, err := NewOAuth1ServiceProvider(config).GetRedirectURL(); !errors.Is(err, ErrResponseTooLarge) {
+		t.Logf("Expecting the response to be too large but found %v.", err)
+		t.Fail()
+	}
+
+	config.RequestTokenVerb = "PATCH"
+	if _, err := NewOAuth1ServiceProvider(config).GetRedirectURL(); err == nil {
+		t.Log("Expecting an unsupported verb to fail.")
+		t.Fail()
+	}
+}
+
+func TestOAuth1ProcessResponseErrors(t *testing.T) {
+	server, provider := newOAuth1TestServer(t, "oauth_token=reqtoken&oauth_token_secret=reqsecret&oauth_callback_confirmed=true")
+	defer server.Close()
+
+	if _, err := provider.ProcessResponse(httptest.NewRequest("GET", "/callback?oauth_token=unknown&oauth_verifier=abc", nil)); !errors.Is(err, ErrStateInvalid) {
+		t.Logf("Expecting an unknown request token to be rejected but found %v.", err)
+		t.Fail()
+	}
+
+	// the provider does not recognise the request token
+	provider.(*OAuth1ServiceProvider).config.StateStore.Put(stateKeyOAuth1Token+"other", "secret")
+	_, err := provider.ProcessResponse(httptest.NewRequest("GET", "/callback?oauth_token=other&oauth_verifier=abc", nil))
+	var problemErr *OAuth1ProblemError
+	if !errors.Is(err, ErrTokenExchange) || !errors.As(err, &problemErr) || problemErr.Problem != "token_rejected" {
+		t.Logf("Expecting a token exchange error but found %v.", err)
+		t.Fail()
+	}
+}
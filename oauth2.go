@@ -1,13 +1,16 @@
 package goauth
 
 import (
+	"context"
+	"crypto/hmac"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -16,9 +19,12 @@ import (
 const (
 	oauth2Code                   = "code"
 	oauth2StateFlag              = "state"
+	oauth2Error                  = "error"
+	oauth2ErrorDescription       = "error_description"
+	oauth2ErrorURI               = "error_uri"
 	oauth2StateFlagPrefix        = "GOAUTH20"
 	oauth2StateFlagError         = "Could not validate state flag: %v."
-	oauth2StateFlagMaxAgeSeconds = 300
+	oauth2StateFlagMaxAgeSeconds = 300 // the default
 )
 
 // NewOAuth2ServiceProvider initializes a new OAuth 2.0 service provider.
@@ -36,9 +42,37 @@ func NewOAuth2ServiceProvider(config OAuth2ServiceProviderConfig) OAuthServicePr
 	}
 
 	provider := &OAuth2ServiceProvider{
-		providerName: strings.ToUpper(config.ProviderName),
-		userInfoURL:  config.UserInfoURL,
-		conf:         conf,
+		providerName:     strings.ToUpper(config.ProviderName),
+		userInfoURL:      config.UserInfoURL,
+		pkceMethod:       config.PKCEMethod,
+		stateStore:       config.StateStore,
+		stateKey:         []byte(config.StateKey),
+		stateMaxAge:      config.StateMaxAgeSeconds,
+		allowUnbound:     config.AllowUnboundState,
+		claims:           config.Claims,
+		conf:             conf,
+		userInfoRequests: config.UserInfoRequests,
+		onTokenRefresh:   config.OnTokenRefresh,
+	}
+	if len(provider.stateKey) == 0 {
+		provider.stateKey = defaultStateKey
+	}
+	if provider.stateMaxAge < 1 {
+		provider.stateMaxAge = oauth2StateFlagMaxAgeSeconds
+	}
+	if provider.stateStore == nil {
+		provider.stateStore = NewMemoryStateStore(defaultStateStoreCapacity, provider.stateMaxAge)
+	}
+	if len(config.Issuer) > 0 && len(provider.claims.UserID) == 0 {
+		// the subject is the only stable identifier of an OpenID Connect user
+		provider.claims.UserID = []string{"sub"}
+	}
+	provider.claims = provider.claims.withDefaults()
+	if len(config.Issuer) > 0 {
+		provider.oidc = newOIDCVerifier(config.Issuer, config.ClientID)
+		if !containsString(conf.Scopes, oidcScope) {
+			provider.conf.Scopes = append([]string{oidcScope}, conf.Scopes...)
+		}
 	}
 	return provider
 }
@@ -66,6 +100,11 @@ type OAuth2ServiceProviderConfig struct {
 	// UserInfoURL is the URL to fetch user data from, once the user is authenticated.
 	UserInfoURL string
 
+	// Issuer is the URL of an OpenID Connect provider. When set the AuthURL, TokenURL and
+	// UserInfoURL are discovered from the issuer's /.well-known/openid-configuration, and
+	// the user data is read from the verified id token rather than the UserInfoURL.
+	Issuer string
+
 	// RedirectURL is the URL where the browser should be sent after authentication.
 	// Often this URL is also provider specific
 	// (eg: http://myserver.com/oauth/callback/[provider_name]).
@@ -74,14 +113,60 @@ type OAuth2ServiceProviderConfig struct {
 	// Scopes are a list of user details requested. Each provider has
 	// their own list of scopes.
 	Scopes []string
+
+	// PKCEMethod enables Proof Key for Code Exchange (RFC 7636) using the given
+	// code challenge method, either "S256" or "plain". Leave empty to disable PKCE.
+	PKCEMethod string
+
+	// StateStore holds the PKCE code verifiers and OpenID Connect nonces between the
+	// redirect and the callback, and records which state flags have been used.
+	// Defaults to an in memory store created for the provider.
+	StateStore StateStore
+
+	// StateKey is the secret used to sign state flags. Defaults to a random key
+	// created when the program starts, so servers running more than one instance
+	// must share a key (and a StateStore).
+	StateKey string
+
+	// StateMaxAgeSeconds is how long the user has to log in with the provider.
+	// Defaults to 300.
+	StateMaxAgeSeconds int
+
+	// AllowUnboundState accepts state flags which are not bound to the user's
+	// browser by BindState, which leaves the login open to cross site request
+	// forgery. Only set it for clients which cannot keep a cookie.
+	AllowUnboundState bool
+
+	// Claims maps the user information to the UserData fields, for providers which
+	// do not use the common names.
+	Claims ClaimMapping
+
+	// OnTokenRefresh is called with each token refreshed by a client returned by
+	// Client, so that it can replace the stored token. Providers which rotate
+	// refresh tokens reject the old refresh token after the first refresh.
+	OnTokenRefresh func(token *Token) error
+
+	// UserInfoRequests are sent after the UserInfoURL, for providers which send the
+	// user's email addresses separately. They are not sent to OpenID Connect
+	// providers, which include the email address in the id token.
+	UserInfoRequests []UserInfoRequest
 }
 
 // OAuth2ServiceProvider is an implementation of the OAuthServiceProvider
 // interface for use in OAuth Version 2.0 authentication.
 type OAuth2ServiceProvider struct {
-	providerName string
-	userInfoURL  string
-	conf         oauth2.Config
+	providerName     string
+	userInfoURL      string
+	pkceMethod       string
+	stateStore       StateStore
+	stateKey         []byte
+	stateMaxAge      int
+	allowUnbound     bool
+	claims           ClaimMapping
+	conf             oauth2.Config
+	userInfoRequests []UserInfoRequest
+	onTokenRefresh   func(token *Token) error
+	oidc             *oidcVerifier
 }
 
 // GetRedirectURL is called when the user first requests to authenticate via OAuth.
@@ -89,41 +174,186 @@ type OAuth2ServiceProvider struct {
 // order to supply the provider with credentials. As an example, if the user is
 // attempting to authenticate via Facebook's API, the user would need to be
 // redirected to Facebook's authentication page.
+//
+// The state flag is not bound to the user's browser, so unless the provider
+// allows unbound state flags the URL is refused: use the Handler, or BindState
+// and GetRedirectURLContext, which protect the login against cross site request
+// forgery.
 func (provider *OAuth2ServiceProvider) GetRedirectURL() (string, error) {
-	return provider.conf.AuthCodeURL(generateStateFlag(provider.providerName)), nil
+	return provider.GetRedirectURLContext(context.Background())
+}
+
+// GetRedirectURLContext is the same as GetRedirectURL, using the context for any
+// outbound requests.
+func (provider *OAuth2ServiceProvider) GetRedirectURLContext(ctx context.Context) (string, error) {
+	conf, err := provider.config(ctx)
+	if err != nil {
+		return "", wrapError(ErrAuthRequest, "%w", err)
+	}
+	stateFlag, err := provider.generateStateFlag(ctx)
+	if err != nil {
+		return "", err
+	}
+	var opts []oauth2.AuthCodeOption
+	if len(provider.pkceMethod) > 0 {
+		verifier, err := generateCodeVerifier()
+		if err != nil {
+			return "", err
+		}
+		challenge, err := createCodeChallenge(verifier, provider.pkceMethod)
+		if err != nil {
+			return "", err
+		}
+		if err = provider.stateStore.Put(stateKeyPKCE+stateFlag, verifier); err != nil {
+			return "", err
+		}
+		opts = append(opts,
+			oauth2.SetAuthURLParam(pkceCodeChallenge, challenge),
+			oauth2.SetAuthURLParam(pkceCodeChallengeMethod, provider.pkceMethod))
+	}
+	if provider.oidc != nil {
+		nonce, err := randomString(16)
+		if err != nil {
+			return "", err
+		}
+		if err = provider.stateStore.Put(stateKeyNonce+stateFlag, nonce); err != nil {
+			return "", err
+		}
+		opts = append(opts, oauth2.SetAuthURLParam(oidcNonce, nonce))
+	}
+	return conf.AuthCodeURL(stateFlag, opts...), nil
 }
 
 // ProcessResponse is called after the user has been successfully authenticated.
 // This method will receive a message back from the OAuth provider containing
-// information about the now authenticated user.
+// information about the now authenticated user. If the provider sent back an
+// error instead, such as the user refusing access, a ProviderError is returned.
 func (provider *OAuth2ServiceProvider) ProcessResponse(request *http.Request) (UserData, error) {
+	return provider.ProcessResponseContext(request.Context(), request)
+}
+
+// ProcessResponseContext is the same as ProcessResponse, using the context for the
+// token exchange and the user info request.
+func (provider *OAuth2ServiceProvider) ProcessResponseContext(ctx context.Context, request *http.Request) (UserData, error) {
 	var user UserData
+	if errorCode := request.FormValue(oauth2Error); len(errorCode) > 0 {
+		// the error must answer one of our requests, or anyone could send users here
+		if err := provider.validateStateFlag(request); err != nil {
+			return user, err
+		}
+		stateFlag := request.FormValue(oauth2StateFlag)
+		provider.stateStore.Delete(stateKeyPKCE + stateFlag)
+		provider.stateStore.Delete(stateKeyNonce + stateFlag)
+		return user, &ProviderError{
+			Code:        errorCode,
+			Description: request.FormValue(oauth2ErrorDescription),
+			URI:         request.FormValue(oauth2ErrorURI),
+			State:       stateFlag,
+		}
+	}
 	if code := request.FormValue(oauth2Code); len(code) > 0 {
 		if err := provider.validateStateFlag(request); err != nil {
 			return user, err
 		}
-		tok, err := provider.conf.Exchange(oauth2.NoContext, code)
-		if err == nil {
-			client := provider.conf.Client(oauth2.NoContext, tok)
-			resp, err := client.Get(provider.userInfoURL)
-			if err == nil {
-				m := make(map[string]interface{})
-				dec := json.NewDecoder(resp.Body)
-				dec.Decode(&m)
-
-				user = toUserData(m)
-				user.OAuthProvider = strings.ToUpper(provider.providerName)
-				user.OAuthVersion = OAuthVersion2
-				user.OAuthToken = tok.AccessToken
-				user.OAuthTokenType = tok.TokenType
-
-				return user, nil
+		conf, err := provider.config(ctx)
+		if err != nil {
+			return user, wrapError(ErrTokenExchange, "Could not fetch the access token: %w", err)
+		}
+		var opts []oauth2.AuthCodeOption
+		if len(provider.pkceMethod) > 0 {
+			key := stateKeyPKCE + request.FormValue(oauth2StateFlag)
+			verifier, err := takeState(provider.stateStore, key)
+			if err != nil {
+				return user, wrapError(ErrStateInvalid, "Could not find the PKCE code verifier for the state flag.")
 			}
+			opts = append(opts, oauth2.SetAuthURLParam(pkceCodeVerifier, verifier))
+		}
+		exchangeCtx, recorder := withTokenResponseRecorder(ctx)
+		tok, err := conf.Exchange(exchangeCtx, code, opts...)
+		if err != nil {
+			return user, tokenExchangeError(err)
+		}
+		if provider.oidc != nil {
+			return provider.processIDToken(ctx, request.FormValue(oauth2StateFlag), newOAuth2Token(tok, recorder.values(), conf.Scopes))
+		}
+		m, raw, err := provider.fetchUserInfo(ctx, conf.Client(ctx, tok))
+		if err != nil {
 			return user, err
 		}
-		return user, err
+		user = provider.claims.userData(m, raw)
+		if len(user.UserID) == 0 {
+			return UserData{}, wrapError(ErrUserInfo, "The user information did not contain a user id.")
+		}
+		user.OAuthProvider = strings.ToUpper(provider.providerName)
+		user.OAuthVersion = OAuthVersion2
+		user.OAuthToken = tok.AccessToken
+		user.OAuthTokenType = tok.TokenType
+		user.Token = newOAuth2Token(tok, recorder.values(), conf.Scopes)
+
+		return user, nil
+	}
+	return user, wrapError(ErrStateInvalid, "No oauth 2.0 code parameter found in the request.")
+}
+
+// Client returns an HTTP client which attaches the token returned by a previous
+// call to ProcessResponse to every request. Expired tokens are refreshed
+// automatically when a refresh token is available, and passed to OnTokenRefresh
+// so they can be stored.
+func (provider *OAuth2ServiceProvider) Client(ctx context.Context, token *Token) (*http.Client, error) {
+	source, err := provider.TokenSource(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+// TokenSource returns a source of valid tokens starting from the token returned
+// by a previous call to ProcessResponse. Expired tokens are refreshed when a
+// refresh token is available, and passed to OnTokenRefresh so they can be stored.
+func (provider *OAuth2ServiceProvider) TokenSource(ctx context.Context, token *Token) (oauth2.TokenSource, error) {
+	if token == nil || len(token.AccessToken) == 0 {
+		return nil, errors.New("No access token to authenticate the client with.")
+	}
+	conf, err := provider.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source := conf.TokenSource(ctx, token.OAuth2Token())
+	if provider.onTokenRefresh == nil {
+		return source, nil
 	}
-	return user, errors.New("No oauth 2.0 code parameter found in the request.")
+	return &refreshingTokenSource{
+		base:        source,
+		accessToken: token.AccessToken,
+		scopes:      token.Scopes,
+		onRefresh:   provider.onTokenRefresh,
+	}, nil
+}
+
+// refreshingTokenSource passes each refreshed token to a callback, since
+// providers which rotate refresh tokens invalidate the stored token.
+type refreshingTokenSource struct {
+	mutex       sync.Mutex
+	base        oauth2.TokenSource
+	accessToken string
+	scopes      []string
+	onRefresh   func(token *Token) error
+}
+
+func (s *refreshingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if tok.AccessToken != s.accessToken {
+		if err = s.onRefresh(newOAuth2Token(tok, nil, s.scopes)); err != nil {
+			return nil, fmt.Errorf("Could not store the refreshed token: %w", err)
+		}
+		s.accessToken = tok.AccessToken
+	}
+	return tok, nil
 }
 
 // GetOAuthVersion gets the version of OAuth implemented by this provider.
@@ -136,41 +366,180 @@ func (provider *OAuth2ServiceProvider) GetProviderName() string {
 	return provider.providerName
 }
 
-func (provider *OAuth2ServiceProvider) validateStateFlag(request *http.Request) error {
-	stateFlag := request.FormValue(oauth2StateFlag)
-	// checks to make sure the state flag is in the request
-	if len(stateFlag) > 0 {
-		// attempts to base64 decode the flag
-		decoded, err := base64.StdEncoding.DecodeString(stateFlag)
+// fetchUserInfo requests the user information using a client authorized with the
+// access token, followed by the further user information requests. The raw JSON
+// is nil for form encoded responses.
+func (provider *OAuth2ServiceProvider) fetchUserInfo(ctx context.Context, client *http.Client) (map[string]interface{}, []byte, error) {
+	value, data, err := provider.getUserInfo(ctx, client, provider.userInfoURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, nil, wrapError(ErrUserInfo, "The user information is not a JSON object.")
+	}
+	for _, request := range provider.userInfoRequests {
+		value, _, err := provider.getUserInfo(ctx, client, request.URL)
 		if err != nil {
-			return fmt.Errorf(oauth2StateFlagError, err.Error())
+			return nil, nil, err
 		}
-		// attempts to split the flag into 3 values
-		vals := strings.Split(string(decoded), "|")
-		if len(vals) != 3 || vals[0] != oauth2StateFlagPrefix {
-			return fmt.Errorf(oauth2StateFlagError, "invalid format")
+		if err = mergeUserInfo(m, request, value); err != nil {
+			return nil, nil, wrapError(ErrUserInfo, "%w", err)
 		}
-		// validates that the provider name has not changed
-		if vals[2] != provider.providerName {
-			return fmt.Errorf(oauth2StateFlagError, "invalid provider")
+	}
+	return m, data, nil
+}
+
+// getUserInfo requests user information from the URL, decoding JSON or form
+// encoded responses.
+func (provider *OAuth2ServiceProvider) getUserInfo(ctx context.Context, client *http.Client, userInfoURL string) (interface{}, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, userInfoURL, nil)
+	if err != nil {
+		return nil, nil, wrapError(ErrUserInfo, "Could not fetch the user information: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, wrapError(ErrUserInfo, "Could not fetch the user information: %w", &TransportError{URL: userInfoURL, Err: err})
+	}
+	defer resp.Body.Close()
+
+	data, err := readResponseBody(resp.Body)
+	if err != nil {
+		return nil, nil, wrapError(ErrUserInfo, "Could not fetch the user information: %w", &TransportError{URL: userInfoURL, Err: err})
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if providerErr := parseProviderError(data); providerErr != nil {
+			return nil, nil, wrapError(ErrUserInfo, "Could not fetch the user information: %w", providerErr)
 		}
-		// validates that the flag is no older than 5 minutes
-		created, err := strconv.Atoi(vals[1])
+		return nil, nil, wrapError(ErrUserInfo, "The user information request failed with status %v.", resp.StatusCode)
+	}
+
+	// providers often send JSON with a text content type
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	value, err := decodeUserInfo(data, mediaType)
+	if err != nil {
+		return nil, nil, wrapError(ErrUserInfo, "Could not decode the user information (%v): %w", mediaType, err)
+	}
+	if mediaType == "application/x-www-form-urlencoded" {
+		data = nil
+	}
+	return value, data, nil
+}
+
+// config returns the oauth2 configuration, filling in the endpoints from the
+// OpenID configuration if this is an OpenID Connect provider.
+func (provider *OAuth2ServiceProvider) config(ctx context.Context) (oauth2.Config, error) {
+	conf := provider.conf
+	if provider.oidc != nil {
+		discovery, err := provider.oidc.discover(ctx)
 		if err != nil {
-			return fmt.Errorf(oauth2StateFlagError, err.Error())
+			return conf, err
 		}
-		ctime := time.Unix(int64(created), 0)
-		dur := time.Now().Sub(ctime)
-		if dur.Seconds() > oauth2StateFlagMaxAgeSeconds {
-			return fmt.Errorf(oauth2StateFlagError, "timed out")
+		conf.Endpoint = oauth2.Endpoint{
+			AuthURL:  discovery.AuthorizationEndpoint,
+			TokenURL: discovery.TokenEndpoint,
 		}
-	} else {
-		return errors.New("Could not validate state flag: no flag found in the request.")
+	}
+	return conf, nil
+}
+
+func (provider *OAuth2ServiceProvider) processIDToken(ctx context.Context, stateFlag string, tok *Token) (UserData, error) {
+	var user UserData
+	if len(tok.IDToken) == 0 {
+		return user, wrapError(ErrTokenExchange, "No id token found in the token response.")
+	}
+	nonce, err := takeState(provider.stateStore, stateKeyNonce+stateFlag)
+	if err != nil {
+		return user, wrapError(ErrStateInvalid, "Could not find the nonce for the state flag.")
+	}
+	claims, err := provider.oidc.verify(ctx, tok.IDToken, nonce)
+	if err != nil {
+		return user, wrapError(ErrUserInfo, "%w", err)
+	}
+
+	user = provider.claims.userData(claims, nil)
+	user.OAuthProvider = strings.ToUpper(provider.providerName)
+	user.OAuthVersion = OAuthVersion2
+	user.OAuthToken = tok.AccessToken
+	user.OAuthTokenType = tok.TokenType
+	user.Token = tok
+
+	return user, nil
+}
+
+func (provider *OAuth2ServiceProvider) validateStateFlag(request *http.Request) error {
+	stateFlag := request.FormValue(oauth2StateFlag)
+	// checks to make sure the state flag is in the request
+	if len(stateFlag) == 0 {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "no flag found in the request")
+	}
+	// splits the flag into the payload and the signature
+	parts := strings.Split(stateFlag, ".")
+	if len(parts) != 2 {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "invalid format")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, err.Error())
+	}
+	vals := strings.Split(string(payload), "|")
+	if len(vals) != 5 || vals[0] != oauth2StateFlagPrefix {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "invalid format")
+	}
+	// validates the signature, which covers the browser binding if there is one
+	var binding string
+	if vals[4] == "1" {
+		binding = stateBindingFromRequest(request)
+	} else if !provider.allowUnbound {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "not bound to the browser")
+	}
+	if !hmac.Equal(signature, signState(provider.stateKey, string(payload), binding)) {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "invalid signature")
+	}
+	// validates that the provider name has not changed
+	if vals[3] != provider.providerName {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "invalid provider")
+	}
+	// validates that the flag is not too old
+	created, err := strconv.ParseInt(vals[2], 10, 64)
+	if err != nil {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, err.Error())
+	}
+	if time.Since(time.Unix(created, 0)).Seconds() > float64(provider.stateMaxAge) {
+		return wrapError(ErrStateExpired, oauth2StateFlagError, "timed out")
+	}
+	// validates that the flag was issued by us and has not been used before. The
+	// store cannot tell a used flag from one it never had or has forgotten.
+	if _, err = takeState(provider.stateStore, stateKeyFlag+vals[1]); err != nil {
+		return wrapError(ErrStateInvalid, oauth2StateFlagError, "unknown or expired")
 	}
 	return nil
 }
 
-func generateStateFlag(provider string) string {
-	stateFlag := fmt.Sprintf("%v|%v|%v", oauth2StateFlagPrefix, time.Now().Unix(), provider)
-	return base64.StdEncoding.EncodeToString([]byte(stateFlag))
+// generateStateFlag creates a random, single use state flag signed with the
+// provider's state key. The flag is bound to the browser if the context was
+// created by BindState.
+func (provider *OAuth2ServiceProvider) generateStateFlag(ctx context.Context) (string, error) {
+	id, err := randomString(18)
+	if err != nil {
+		return "", err
+	}
+	binding := stateBindingFromContext(ctx)
+	bound := "0"
+	if len(binding) > 0 {
+		bound = "1"
+	} else if !provider.allowUnbound {
+		return "", errors.New("The state flag is not bound to the browser: use the context returned by BindState, or set AllowUnboundState.")
+	}
+	payload := fmt.Sprintf("%v|%v|%v|%v|%v", oauth2StateFlagPrefix, id, time.Now().Unix(), provider.providerName, bound)
+	if err = provider.stateStore.Put(stateKeyFlag+id, payload); err != nil {
+		return "", err
+	}
+	signature := signState(provider.stateKey, payload, binding)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
 }
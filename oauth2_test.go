@@ -1,7 +1,14 @@
 package goauth
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -15,6 +22,8 @@ var providerMap = map[string]interface{}{
 		UserInfoURL:  "https://www.googleapis.com/oauth2/v2/userinfo",
 		RedirectURL:  "http://myserver.com/oauth/callback/google",
 		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.profile", "https://www.googleapis.com/auth/userinfo.email"},
+		// the tests log in without a browser
+		AllowUnboundState: true,
 	},
 	"facebook": OAuth2ServiceProviderConfig{
 		ProviderName: "FACEBOOK",
@@ -25,6 +34,8 @@ var providerMap = map[string]interface{}{
 		UserInfoURL:  "https://graph.facebook.com/me?fields=id,first_name,middle_name,last_name,email,picture",
 		RedirectURL:  "http://myserver.com/oauth/callback/facebook",
 		Scopes:       []string{"public_profile", "email"},
+		// the tests log in without a browser
+		AllowUnboundState: true,
 	},
 }
 
@@ -70,3 +81,261 @@ func TestGetRedirectURL(t *testing.T) {
 		t.Logf("Url %v is not valid.", url2)
 	}
 }
+
+func TestProcessResponseContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	provider := NewOAuth2ServiceProvider(config)
+
+	redirectURL, _ := provider.GetRedirectURL()
+	redirect, _ := url.Parse(redirectURL)
+	request := httptest.NewRequest("GET", "/oauth/callback/google?code=xyz&state="+url.QueryEscape(redirect.Query().Get(oauth2StateFlag)), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := provider.ProcessResponseContext(ctx, request); err == nil {
+		t.Log("Expecting a canceled context to abort the token exchange.")
+		t.Fail()
+	}
+}
+
+func TestProcessResponseMissingCode(t *testing.T) {
+	provider := NewOAuth2ServiceProvider(providerMap["google"].(OAuth2ServiceProviderConfig))
+	if _, err := provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/google", nil)); !errors.Is(err, ErrStateInvalid) {
+		t.Logf("Expecting a response without a code to be rejected but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestValidateStateFlag(t *testing.T) {
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.StateKey = "secret"
+	provider := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+
+	callback := func(stateFlag string, cookies ...*http.Cookie) *http.Request {
+		request := httptest.NewRequest("GET", "/oauth/callback/google?code=xyz&state="+url.QueryEscape(stateFlag), nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		return request
+	}
+
+	// state flags are single use
+	stateFlag, _ := provider.generateStateFlag(context.Background())
+	if err := provider.validateStateFlag(callback(stateFlag)); err != nil {
+		t.Log(err.Error())
+		t.Fail()
+	}
+	if err := provider.validateStateFlag(callback(stateFlag)); !errors.Is(err, ErrStateInvalid) || !strings.Contains(err.Error(), "unknown or expired") {
+		t.Logf("Expecting a reused state flag to be rejected but was %v.", err)
+		t.Fail()
+	}
+
+	// only one of several concurrent callbacks with the same flag is accepted
+	provider.stateStore = slowStateStore{newTokenCache(10, 300)}
+	stateFlag, _ = provider.generateStateFlag(context.Background())
+	var accepted int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if provider.validateStateFlag(callback(stateFlag)) == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if accepted != 1 {
+		t.Logf("Expecting one concurrent callback to be accepted but was %v.", accepted)
+		t.Fail()
+	}
+
+	// bound state flags require the browser's cookie
+	rec := httptest.NewRecorder()
+	ctx, _ := BindState(rec, httptest.NewRequest("GET", "/oauth/login/google", nil))
+	cookie := rec.Result().Cookies()[0]
+	stateFlag, _ = provider.generateStateFlag(ctx)
+	if err := provider.validateStateFlag(callback(stateFlag, &http.Cookie{Name: stateCookieName, Value: "attacker"})); err == nil {
+		t.Log("Expecting a state flag from another browser to be rejected.")
+		t.Fail()
+	}
+	if err := provider.validateStateFlag(callback(stateFlag, cookie)); err != nil {
+		t.Log(err.Error())
+		t.Fail()
+	}
+
+	// forged and expired flags are rejected
+	other := NewOAuth2ServiceProvider(providerMap["google"].(OAuth2ServiceProviderConfig)).(*OAuth2ServiceProvider)
+	stateFlag, _ = other.generateStateFlag(context.Background())
+	if err := provider.validateStateFlag(callback(stateFlag)); !errors.Is(err, ErrStateInvalid) {
+		t.Log("Expecting a state flag signed with another key to be rejected.")
+		t.Fail()
+	}
+	provider.stateMaxAge = -1
+	stateFlag, _ = provider.generateStateFlag(context.Background())
+	if err := provider.validateStateFlag(callback(stateFlag)); !errors.Is(err, ErrStateExpired) {
+		t.Log("Expecting an expired state flag to be rejected.")
+		t.Fail()
+	}
+
+	// unless allowed, state flags must be bound to the browser
+	config.AllowUnboundState = false
+	strict := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+	if _, err := strict.GetRedirectURL(); err == nil {
+		t.Log("Expecting an unbound redirect URL to be refused.")
+		t.Fail()
+	}
+	provider.stateMaxAge = 300
+	stateFlag, _ = provider.generateStateFlag(context.Background())
+	if err := strict.validateStateFlag(callback(stateFlag)); !errors.Is(err, ErrStateInvalid) {
+		t.Log("Expecting an unbound state flag to be rejected.")
+		t.Fail()
+	}
+	stateFlag, _ = strict.generateStateFlag(ctx)
+	if err := strict.validateStateFlag(callback(stateFlag, cookie)); err != nil {
+		t.Log(err.Error())
+		t.Fail()
+	}
+}
+
+func TestOAuth2TokenExchangeError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"The code has expired.","error_uri":"https://example.com/errors"}`))
+	}))
+	defer server.Close()
+
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	provider := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+	stateFlag, _ := provider.generateStateFlag(context.Background())
+
+	_, err := provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/google?code=xyz&state="+url.QueryEscape(stateFlag), nil))
+	var providerErr *ProviderError
+	if !errors.Is(err, ErrTokenExchange) || !errors.As(err, &providerErr) {
+		t.Logf("Expecting a token exchange error but found %v.", err)
+		t.FailNow()
+	}
+	if providerErr.Code != "invalid_grant" || providerErr.Description != "The code has expired." || providerErr.URI != "https://example.com/errors" {
+		t.Logf("Invalid provider error %v.", providerErr)
+		t.Fail()
+	}
+}
+
+func TestOAuth2ErrorResponse(t *testing.T) {
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.PKCEMethod = PKCEMethodS256
+	config.StateStore = NewMemoryStateStore(10, 300)
+	provider := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+
+	redirectURL, _ := provider.GetRedirectURL()
+	u, _ := url.Parse(redirectURL)
+	stateFlag := u.Query().Get(oauth2StateFlag)
+	query := url.Values{
+		"error":             []string{"access_denied"},
+		"error_description": []string{"The user cancelled."},
+		"state":             []string{stateFlag},
+	}
+
+	_, err := provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/google?"+query.Encode(), nil))
+	var providerErr *ProviderError
+	if !errors.Is(err, ErrAccessDenied) || !errors.As(err, &providerErr) {
+		t.Logf("Expecting access to be denied but found %v.", err)
+		t.FailNow()
+	}
+	if providerErr.Description != "The user cancelled." || providerErr.State != stateFlag {
+		t.Logf("Invalid provider error %v.", providerErr)
+		t.Fail()
+	}
+	if size := config.StateStore.(*tokenCache).size(); size != 0 {
+		t.Logf("Expecting the state to be removed but found %v items.", size)
+		t.Fail()
+	}
+
+	// errors which do not answer a request are rejected
+	query.Set("state", "forged")
+	if _, err = provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/google?"+query.Encode(), nil)); !errors.Is(err, ErrStateInvalid) {
+		t.Logf("Expecting an invalid state but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestOAuth2UserInfo(t *testing.T) {
+	var contentType, body string
+	var status int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"abc","token_type":"Bearer"}`))
+		default:
+			w.Header().Set("Content-Type", contentType)
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	config.UserInfoURL = server.URL + "/userinfo"
+	provider := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+	login := func() (UserData, error) {
+		stateFlag, _ := provider.generateStateFlag(context.Background())
+		return provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/google?code=xyz&state="+url.QueryEscape(stateFlag), nil))
+	}
+
+	contentType, status, body = "application/json; charset=utf-8", http.StatusOK, `{"id":12345,"name":"Jane Doe"}`
+	if user, err := login(); err != nil || user.UserID != "12345" || user.FullName != "Jane Doe" {
+		t.Logf("Invalid user %v (%v).", user, err)
+		t.Fail()
+	}
+	body = `{"id":12345,"name":null,"email":42,"login":"jdoe","plan":{"name":"pro"}}`
+	if user, err := login(); err != nil || user.UserID != "12345" || user.FullName != "" || user.Email != "42" ||
+		user.ClaimString("login") != "jdoe" || user.ClaimString("plan.name") != "pro" || string(user.RawJSON) != body {
+		t.Logf("Invalid user with extra claims %v (%v).", user, err)
+		t.Fail()
+	}
+	contentType, body = "application/x-www-form-urlencoded", "id=12345&name=Jane+Doe"
+	if user, err := login(); err != nil || user.UserID != "12345" || user.FullName != "Jane Doe" || string(user.RawJSON) != `{"id":"12345","name":"Jane Doe"}` {
+		t.Logf("Invalid form encoded user %v (%v).", user, err)
+		t.Fail()
+	}
+
+	var providerErr *ProviderError
+	contentType, status, body = "application/json", http.StatusUnauthorized, `{"error":"invalid_token"}`
+	if _, err := login(); !errors.Is(err, ErrUserInfo) || !errors.As(err, &providerErr) || providerErr.Code != "invalid_token" {
+		t.Logf("Expecting the rejected token to be reported but found %v.", err)
+		t.Fail()
+	}
+	contentType, status, body = "text/html", http.StatusBadGateway, "<html></html>"
+	if _, err := login(); !errors.Is(err, ErrUserInfo) {
+		t.Logf("Expecting the failure to be reported but found %v.", err)
+		t.Fail()
+	}
+	status = http.StatusOK
+	if _, err := login(); !errors.Is(err, ErrUserInfo) {
+		t.Logf("Expecting an invalid body to be reported but found %v.", err)
+		t.Fail()
+	}
+	contentType, body = "application/json", `{"name":"Jane Doe"}`
+	if _, err := login(); !errors.Is(err, ErrUserInfo) {
+		t.Logf("Expecting a missing id to be reported but found %v.", err)
+		t.Fail()
+	}
+	body = `{"id":"` + strings.Repeat("1", maxResponseSize) + `"}`
+	if _, err := login(); !errors.Is(err, ErrResponseTooLarge) {
+		t.Logf("Expecting the response to be too large but found %v.", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,346 @@
+package goauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcScope            = "openid"
+	oidcNonce            = "nonce"
+	oidcIDToken          = "id_token"
+	oidcDiscoveryPath    = "/.well-known/openid-configuration"
+	oidcClockSkewSeconds = 60
+	oidcIDTokenError     = "Could not validate id token: %v."
+
+	// the key set is fetched again for an unknown key id at most this often
+	oidcKeyRefreshInterval = time.Minute
+	oidcDefaultTimeout     = 10 * time.Second
+)
+
+// the client used to fetch the discovery document and key set when the context
+// does not carry one.
+var oidcHTTPClient = &http.Client{Timeout: oidcDefaultTimeout}
+
+// oidcDiscovery holds the parts of the OpenID Provider metadata used by this
+// library.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// oidcVerifier discovers the OpenID provider configuration and validates
+// the id tokens it issues. The discovery document and key set are cached.
+type oidcVerifier struct {
+	issuer      string
+	clientID    string
+	discovery   *oidcDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	keysFetch   chan struct{} // closed when the key set being fetched arrives
+	mutex       *sync.Mutex
+}
+
+func newOIDCVerifier(issuer, clientID string) *oidcVerifier {
+	return &oidcVerifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		keys:     make(map[string]crypto.PublicKey),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// discover fetches the provider configuration the first time it is called.
+func (v *oidcVerifier) discover(ctx context.Context) (*oidcDiscovery, error) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.discovery != nil {
+		return v.discovery, nil
+	}
+	discovery := &oidcDiscovery{}
+	if err := getJSON(ctx, v.issuer+oidcDiscoveryPath, discovery); err != nil {
+		return nil, fmt.Errorf("Could not fetch OpenID configuration for %v: %v.", v.issuer, err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("OpenID configuration issuer %v does not match %v.", discovery.Issuer, v.issuer)
+	}
+	v.discovery = discovery
+	return discovery, nil
+}
+
+// verify validates the signature and the claims of the id token, returning the
+// claims if the token is valid.
+func (v *oidcVerifier) verify(ctx context.Context, idToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf(oidcIDTokenError, "invalid format")
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+	key, err := v.getKey(ctx, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+	if err = verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+
+	claims := make(map[string]interface{})
+	if err = decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+	if err = v.validateClaims(claims, nonce); err != nil {
+		return nil, fmt.Errorf(oidcIDTokenError, err.Error())
+	}
+	return claims, nil
+}
+
+func (v *oidcVerifier) validateClaims(claims map[string]interface{}, nonce string) error {
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != v.issuer {
+		return errors.New("invalid issuer")
+	}
+	if !v.hasAudience(claims["aud"]) {
+		return errors.New("invalid audience")
+	}
+	if azp, found := claims["azp"].(string); found && azp != v.clientID {
+		return errors.New("invalid authorized party")
+	}
+	now := time.Now().Unix()
+	exp, found := claims["exp"].(float64)
+	if !found || int64(exp)+oidcClockSkewSeconds < now {
+		return errors.New("token expired")
+	}
+	iat, found := claims["iat"].(float64)
+	if !found || int64(iat)-oidcClockSkewSeconds > now {
+		return errors.New("token issued in the future")
+	}
+	if tokenNonce, _ := claims[oidcNonce].(string); tokenNonce != nonce {
+		return errors.New("invalid nonce")
+	}
+	if sub, _ := claims["sub"].(string); len(sub) == 0 {
+		return errors.New("missing subject")
+	}
+	return nil
+}
+
+func (v *oidcVerifier) hasAudience(aud interface{}) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == v.clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == v.clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getKey finds the key with the given id, refreshing the cached key set if
+// the provider has rotated its keys. The key set is fetched without holding the
+// lock, by one caller at a time and at most once per oidcKeyRefreshInterval, so
+// that unknown key ids cannot make every login wait for the provider.
+func (v *oidcVerifier) getKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	discovery, err := v.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mutex.Lock()
+	if key, found := v.keys[kid]; found {
+		v.mutex.Unlock()
+		return key, nil
+	}
+	fetch := v.keysFetch
+	if fetch != nil {
+		// another caller is fetching the key set
+		v.mutex.Unlock()
+		select {
+		case <-fetch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	} else {
+		if time.Since(v.keysFetched) < oidcKeyRefreshInterval {
+			v.mutex.Unlock()
+			return nil, fmt.Errorf("no key found with id %v", kid)
+		}
+		fetch = make(chan struct{})
+		v.keysFetch = fetch
+		v.keysFetched = time.Now()
+		v.mutex.Unlock()
+
+		keys, err := fetchKeySet(ctx, discovery.JWKSURI)
+		v.mutex.Lock()
+		if err == nil {
+			v.keys = keys
+		}
+		v.keysFetch = nil
+		close(fetch)
+		v.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if key, found := v.keys[kid]; found {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key found with id %v", kid)
+}
+
+// fetchKeySet fetches the provider's keys, skipping the keys which are not
+// supported.
+func fetchKeySet(ctx context.Context, jwksURI string) (map[string]crypto.PublicKey, error) {
+	keySet := jsonWebKeySet{}
+	if err := getJSON(ctx, jwksURI, &keySet); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(keySet.Keys))
+	for _, jwk := range keySet.Keys {
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %v", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %v", jwk.Kty)
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256", "PS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an RSA key")
+		}
+		if alg == "RS256" {
+			return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, hash[:], signature)
+		}
+		return rsa.VerifyPSS(rsaKey, crypto.SHA256, hash[:], signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key is not an EC key")
+		}
+		if len(signature) != 64 {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, hash[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %v", alg)
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getJSON fetches a JSON document from the provider, using the client carried by
+// the context like the oauth2 package does.
+func getJSON(ctx context.Context, url string, v interface{}) error {
+	client := oidcHTTPClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &TransportError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+	data, err := readResponseBody(resp.Body)
+	if err != nil {
+		return &TransportError{URL: url, Err: err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,262 @@
+package goauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testOIDCServer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	alg    string
+	claims map[string]interface{}
+
+	keyFetches int32
+}
+
+func newTestOIDCServer(t *testing.T) *testOIDCServer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testOIDCServer{rsaKey: rsaKey, ecKey: ecKey, alg: "RS256"}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+func (s *testOIDCServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	switch r.URL.Path {
+	case oidcDiscoveryPath:
+		enc.Encode(map[string]string{
+			"issuer":                 s.URL,
+			"authorization_endpoint": s.URL + "/auth",
+			"token_endpoint":         s.URL + "/token",
+			"userinfo_endpoint":      s.URL + "/userinfo",
+			"jwks_uri":               s.URL + "/jwks",
+		})
+	case "/jwks":
+		atomic.AddInt32(&s.keyFetches, 1)
+		b64 := base64.RawURLEncoding.EncodeToString
+		enc.Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "n": b64(s.rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(s.rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(s.ecKey.X.Bytes()), "y": b64(s.ecKey.Y.Bytes())},
+		}})
+	case "/token":
+		enc.Encode(map[string]interface{}{
+			"access_token": "abc",
+			"token_type":   "Bearer",
+			"id_token":     s.sign(s.claims),
+		})
+	}
+}
+
+func (s *testOIDCServer) sign(claims map[string]interface{}) string {
+	kid := "rsa"
+	if s.alg == "ES256" {
+		kid = "ec"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch s.alg {
+	case "RS256":
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, crypto.SHA256, hash[:])
+	case "PS256":
+		signature, _ = rsa.SignPSS(rand.Reader, s.rsaKey, crypto.SHA256, hash[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case "ES256":
+		r, sig, _ := ecdsa.Sign(rand.Reader, s.ecKey, hash[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		sig.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *testOIDCServer) login(t *testing.T, provider OAuthServiceProvider, claims func(nonce string) map[string]interface{}) (UserData, error) {
+	redirectURL, err := provider.GetRedirectURL()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	redirect, _ := url.Parse(redirectURL)
+	query := redirect.Query()
+	s.claims = claims(query.Get(oidcNonce))
+
+	callback := "/oauth/callback/test?code=xyz&state=" + url.QueryEscape(query.Get(oauth2StateFlag))
+	return provider.ProcessResponse(httptest.NewRequest("GET", callback, nil))
+}
+
+func TestOIDCProcessResponse(t *testing.T) {
+	server := newTestOIDCServer(t)
+	defer server.Close()
+
+	provider := NewOAuth2ServiceProvider(OAuth2ServiceProviderConfig{
+		ProviderName:      "test",
+		ClientID:          "CLIENT_ID",
+		ClientSecret:      "CLIENT_SECRET",
+		Issuer:            server.URL,
+		RedirectURL:       "http://myserver.com/oauth/callback/test",
+		Scopes:            []string{"email", "profile"},
+		AllowUnboundState: true,
+	})
+	validClaims := func(nonce string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   server.URL,
+			"aud":   "CLIENT_ID",
+			"sub":   "248289761001",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": nonce,
+			"name":  "Jane Doe",
+			"email": "janedoe@example.com",
+		}
+	}
+
+	for _, alg := range []string{"RS256", "PS256", "ES256"} {
+		server.alg = alg
+		user, err := server.login(t, provider, validClaims)
+		if err != nil {
+			t.Logf("%v: %v", alg, err.Error())
+			t.Fail()
+		}
+		if user.UserID != "248289761001" || user.Email != "janedoe@example.com" || user.FullName != "Jane Doe" {
+			t.Logf("%v: invalid user %v.", alg, user)
+			t.Fail()
+		}
+	}
+
+	server.alg = "RS256"
+	invalidClaims := map[string]func(claims map[string]interface{}){
+		"issuer":   func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" },
+		"audience": func(claims map[string]interface{}) { claims["aud"] = []string{"OTHER"} },
+		"expired":  func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":   func(claims map[string]interface{}) { claims["iat"] = time.Now().Add(time.Hour).Unix() },
+		"nonce":    func(claims map[string]interface{}) { claims["nonce"] = "replayed" },
+	}
+	for name, invalidate := range invalidClaims {
+		_, err := server.login(t, provider, func(nonce string) map[string]interface{} {
+			claims := validClaims(nonce)
+			invalidate(claims)
+			return claims
+		})
+		if err == nil {
+			t.Logf("Expecting %v to be rejected.", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestOIDCRedirectURL(t *testing.T) {
+	server := newTestOIDCServer(t)
+	defer server.Close()
+
+	provider := NewOAuth2ServiceProvider(OAuth2ServiceProviderConfig{
+		ProviderName:      "test",
+		ClientID:          "CLIENT_ID",
+		ClientSecret:      "CLIENT_SECRET",
+		Issuer:            server.URL + "/",
+		RedirectURL:       "http://myserver.com/oauth/callback/test",
+		AllowUnboundState: true,
+	})
+	redirectURL, err := provider.GetRedirectURL()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	redirect, _ := url.Parse(redirectURL)
+	if redirect.Path != "/auth" || redirect.Query().Get("scope") != oidcScope || len(redirect.Query().Get(oidcNonce)) == 0 {
+		t.Logf("Url %v is not valid.", redirectURL)
+		t.Fail()
+	}
+}
+
+func TestOIDCDiscoveryErrors(t *testing.T) {
+	server := newTestOIDCServer(t)
+	defer server.Close()
+	impostor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"issuer":"https://evil.example.com"}`))
+	}))
+	defer impostor.Close()
+
+	// the configuration cannot be fetched, or is for another issuer
+	for _, issuer := range []string{server.URL + "/missing", impostor.URL} {
+		provider := NewOAuth2ServiceProvider(OAuth2ServiceProviderConfig{
+			ProviderName: "test",
+			ClientID:     "CLIENT_ID",
+			ClientSecret: "CLIENT_SECRET",
+			Issuer:       issuer,
+			RedirectURL:  "http://myserver.com/oauth/callback/test",
+		})
+		if _, err := provider.GetRedirectURL(); !errors.Is(err, ErrAuthRequest) {
+			t.Logf("Expecting the discovery of %v to fail but found %v.", issuer, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestOIDCKeyRefresh(t *testing.T) {
+	server := newTestOIDCServer(t)
+	defer server.Close()
+
+	verifier := newOIDCVerifier(server.URL, "CLIENT_ID")
+	if _, err := verifier.getKey(context.Background(), "rsa"); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	// unknown key ids only fetch the key set again once the interval has passed
+	for i := 0; i < 10; i++ {
+		if _, err := verifier.getKey(context.Background(), "unknown"); err == nil {
+			t.Log("Expecting an unknown key id to be rejected.")
+			t.Fail()
+		}
+	}
+	if fetches := atomic.LoadInt32(&server.keyFetches); fetches != 1 {
+		t.Logf("Expecting the key set to be fetched once but was fetched %v times.", fetches)
+		t.Fail()
+	}
+	verifier.keysFetched = time.Now().Add(-oidcKeyRefreshInterval)
+	verifier.getKey(context.Background(), "unknown")
+	if fetches := atomic.LoadInt32(&server.keyFetches); fetches != 2 {
+		t.Logf("Expecting the key set to be fetched again but was fetched %v times.", fetches)
+		t.Fail()
+	}
+}
+
+func TestOIDCResponseTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issuer":"`))
+		w.Write(make([]byte, maxResponseSize))
+	}))
+	defer server.Close()
+	var discovery oidcDiscovery
+	if err := getJSON(context.Background(), server.URL, &discovery); !errors.Is(err, ErrResponseTooLarge) {
+		t.Logf("Expecting the response to be too large but found %v.", err)
+		t.Fail()
+	}
+}
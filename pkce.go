@@ -0,0 +1,49 @@
+package goauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// PKCE (RFC 7636) code challenge methods.
+const (
+	PKCEMethodS256  = "S256"
+	PKCEMethodPlain = "plain"
+)
+
+const (
+	pkceCodeChallenge       = "code_challenge"
+	pkceCodeChallengeMethod = "code_challenge_method"
+	pkceCodeVerifier        = "code_verifier"
+	pkceVerifierBytes       = 32
+)
+
+// generateCodeVerifier creates a high entropy code verifier. 32 random bytes
+// encode to a 43 character string, the minimum length allowed by the spec.
+func generateCodeVerifier() (string, error) {
+	return randomString(pkceVerifierBytes)
+}
+
+// createCodeChallenge derives the code challenge sent to the provider from
+// the code verifier.
+func createCodeChallenge(verifier, method string) (string, error) {
+	switch method {
+	case PKCEMethodS256:
+		sum := sha256.Sum256([]byte(verifier))
+		return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+	case PKCEMethodPlain:
+		return verifier, nil
+	}
+	return "", fmt.Errorf("Unsupported PKCE code challenge method %v.", method)
+}
+
+// validatePKCEMethod reports a code challenge method other than S256 or plain.
+// An empty method disables PKCE.
+func validatePKCEMethod(method string) error {
+	switch method {
+	case "", PKCEMethodS256, PKCEMethodPlain:
+		return nil
+	}
+	return fmt.Errorf("Unsupported PKCE code challenge method %v.", method)
+}
@@ -0,0 +1,99 @@
+package goauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCreateCodeChallenge(t *testing.T) {
+	// test vector from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+
+	challenge, err := createCodeChallenge(verifier, PKCEMethodS256)
+	if err != nil || challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Logf("Invalid S256 challenge %v (%v).", challenge, err)
+		t.Fail()
+	}
+	challenge, err = createCodeChallenge(verifier, PKCEMethodPlain)
+	if err != nil || challenge != verifier {
+		t.Logf("Invalid plain challenge %v (%v).", challenge, err)
+		t.Fail()
+	}
+	if _, err = createCodeChallenge(verifier, "S512"); err == nil {
+		t.Log("Expected an error for an unsupported method.")
+		t.Fail()
+	}
+}
+
+func TestGenerateCodeVerifier(t *testing.T) {
+	verifier, err := generateCodeVerifier()
+	if err != nil {
+		t.Log(err.Error())
+		t.Fail()
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Logf("Verifier %v has an invalid length %v.", verifier, len(verifier))
+		t.Fail()
+	}
+}
+
+func TestPKCEExchange(t *testing.T) {
+	var sentVerifier string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			sentVerifier = r.PostForm.Get(pkceCodeVerifier)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"abc","token_type":"Bearer"}`))
+		case "/userinfo":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": 12345, "name": "Jane Doe"})
+		}
+	}))
+	defer server.Close()
+
+	provider := NewOAuth2ServiceProvider(OAuth2ServiceProviderConfig{
+		ProviderName:      "test",
+		ClientID:          "CLIENT_ID",
+		ClientSecret:      "CLIENT_SECRET",
+		AuthURL:           server.URL + "/auth",
+		TokenURL:          server.URL + "/token",
+		UserInfoURL:       server.URL + "/userinfo",
+		RedirectURL:       "http://myserver.com/oauth/callback/test",
+		PKCEMethod:        PKCEMethodS256,
+		AllowUnboundState: true,
+	})
+
+	redirectURL, err := provider.GetRedirectURL()
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	redirect, _ := url.Parse(redirectURL)
+	query := redirect.Query()
+	if query.Get(pkceCodeChallengeMethod) != PKCEMethodS256 || len(query.Get(pkceCodeChallenge)) == 0 {
+		t.Logf("Url %v does not contain a code challenge.", redirectURL)
+		t.Fail()
+	}
+
+	callback := "/oauth/callback/test?code=xyz&state=" + url.QueryEscape(query.Get(oauth2StateFlag))
+	user, err := provider.ProcessResponse(httptest.NewRequest("GET", callback, strings.NewReader("")))
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if user.UserID != "12345" {
+		t.Logf("Expecting user id to be 12345 but was %v.", user.UserID)
+		t.Fail()
+	}
+	challenge, _ := createCodeChallenge(sentVerifier, PKCEMethodS256)
+	if challenge != query.Get(pkceCodeChallenge) {
+		t.Logf("Verifier %v does not match challenge %v.", sentVerifier, query.Get(pkceCodeChallenge))
+		t.Fail()
+	}
+}
@@ -0,0 +1,406 @@
+package goauth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// placeholders in the URLs of presets which are hosted per customer.
+const (
+	presetDomain = "{domain}"
+	presetTenant = "{tenant}"
+)
+
+// Preset is the configuration of a well known provider, so that only the client
+// id and secret need to be supplied. A preset is selected in a configuration file
+// with the Preset key (eg: {"Preset":"github"}), and any other key overrides the
+// value of the preset.
+type Preset struct {
+
+	// Name is the lower case name of the preset (eg: github).
+	Name string
+
+	// OAuthVersion is the version of OAuth implemented by the provider.
+	OAuthVersion string
+
+	// AuthURL is the authentication URL.
+	AuthURL string
+
+	// TokenURL is the URL that assigns a token to the user.
+	TokenURL string
+
+	// UserInfoURL is the URL to fetch user data from, once the user is authenticated.
+	UserInfoURL string
+
+	// RequestTokenURL is the URL used to fetch the oauth token of OAuth 1.0 providers.
+	RequestTokenURL string
+
+	// Issuer is the URL of an OpenID Connect provider.
+	Issuer string
+
+	// Scopes are the scopes needed to read the user's profile and email address.
+	Scopes []string
+
+	// Claims maps the provider's user information to the UserData fields.
+	Claims ClaimMapping
+
+	// UserInfoRequests fetch the user's email addresses from providers which send
+	// them separately.
+	UserInfoRequests []UserInfoRequest
+
+	// Domain replaces {domain} in the URLs of providers which are hosted per
+	// customer (eg: dev-123456.okta.com). A Domain in the configuration file
+	// overrides it.
+	Domain string
+
+	// Tenant replaces {tenant} in the URLs of providers with more than one
+	// directory (eg: the Microsoft tenant id). A Tenant in the configuration file
+	// overrides it.
+	Tenant string
+}
+
+var (
+	presetsMutex sync.RWMutex
+	presets      = map[string]Preset{
+		"google": {
+			Name:         "google",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			TokenURL:     "https://oauth2.googleapis.com/token",
+			UserInfoURL:  "https://openidconnect.googleapis.com/v1/userinfo",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims:       ClaimMapping{UserID: []string{"sub"}},
+		},
+		"github": {
+			Name:         "github",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://github.com/login/oauth/authorize",
+			TokenURL:     "https://github.com/login/oauth/access_token",
+			UserInfoURL:  "https://api.github.com/user",
+			Scopes:       []string{"read:user", "user:email"},
+			Claims: ClaimMapping{
+				ScreenName: []string{"login"},
+				PhotoURL:   []string{"avatar_url"},
+			},
+			UserInfoRequests: []UserInfoRequest{
+				{URL: "https://api.github.com/user/emails", Claim: "emails"},
+			},
+		},
+		"gitlab": {
+			Name:         "gitlab",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://{domain}/oauth/authorize",
+			TokenURL:     "https://{domain}/oauth/token",
+			UserInfoURL:  "https://{domain}/api/v4/user",
+			Scopes:       []string{"read_user"},
+			Claims: ClaimMapping{
+				ScreenName: []string{"username"},
+				PhotoURL:   []string{"avatar_url"},
+			},
+			Domain: "gitlab.com",
+		},
+		"microsoft": {
+			Name:         "microsoft",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://login.microsoftonline.com/{tenant}/oauth2/v2.0/authorize",
+			TokenURL:     "https://login.microsoftonline.com/{tenant}/oauth2/v2.0/token",
+			UserInfoURL:  "https://graph.microsoft.com/oidc/userinfo",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims:       ClaimMapping{UserID: []string{"sub"}},
+			Tenant:       "common",
+		},
+		"facebook": {
+			Name:         "facebook",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://www.facebook.com/dialog/oauth",
+			TokenURL:     "https://graph.facebook.com/oauth/access_token",
+			UserInfoURL:  "https://graph.facebook.com/me?fields=id,name,first_name,last_name,email,picture",
+			Scopes:       []string{"public_profile", "email"},
+		},
+		"twitter": {
+			Name:            "twitter",
+			OAuthVersion:    OAuthVersion1,
+			AuthURL:         "https://api.twitter.com/oauth/authorize",
+			TokenURL:        "https://api.twitter.com/oauth/access_token",
+			UserInfoURL:     "https://api.twitter.com/1.1/account/verify_credentials.json?include_email=true&skip_status=true",
+			RequestTokenURL: "https://api.twitter.com/oauth/request_token",
+			Claims: ClaimMapping{
+				UserID:   []string{"id_str", "id"},
+				PhotoURL: []string{"profile_image_url_https", "profile_image_url"},
+			},
+		},
+		"linkedin": {
+			Name:         "linkedin",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://www.linkedin.com/oauth/v2/authorization",
+			TokenURL:     "https://www.linkedin.com/oauth/v2/accessToken",
+			UserInfoURL:  "https://api.linkedin.com/v2/userinfo",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims:       ClaimMapping{UserID: []string{"sub"}},
+		},
+		"slack": {
+			Name:         "slack",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://slack.com/openid/connect/authorize",
+			TokenURL:     "https://slack.com/api/openid.connect.token",
+			UserInfoURL:  "https://slack.com/api/openid.connect.userinfo",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims:       ClaimMapping{UserID: []string{"sub"}},
+		},
+		"discord": {
+			Name:         "discord",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://discord.com/api/oauth2/authorize",
+			TokenURL:     "https://discord.com/api/oauth2/token",
+			UserInfoURL:  "https://discord.com/api/users/@me",
+			Scopes:       []string{"identify", "email"},
+			Claims: ClaimMapping{
+				EmailVerified: []string{"verified"},
+				FullName:      []string{"global_name", "username"},
+				ScreenName:    []string{"username"},
+			},
+		},
+		"bitbucket": {
+			Name:         "bitbucket",
+			OAuthVersion: OAuthVersion2,
+			AuthURL:      "https://bitbucket.org/site/oauth2/authorize",
+			TokenURL:     "https://bitbucket.org/site/oauth2/access_token",
+			UserInfoURL:  "https://api.bitbucket.org/2.0/user",
+			Scopes:       []string{"account", "email"},
+			Claims: ClaimMapping{
+				UserID:     []string{"uuid", "account_id"},
+				FullName:   []string{"display_name"},
+				ScreenName: []string{"username", "nickname"},
+				PhotoURL:   []string{"links.avatar.href"},
+			},
+			UserInfoRequests: []UserInfoRequest{
+				{URL: "https://api.bitbucket.org/2.0/user/emails", Claim: "emails"},
+			},
+		},
+		"apple": {
+			// Apple only sends the user's name and email address to callbacks which
+			// accept a form post, so no scopes are requested and the user is known
+			// by the subject of the id token.
+			Name:         "apple",
+			OAuthVersion: OAuthVersion2,
+			Issuer:       "https://appleid.apple.com",
+		},
+		"okta": {
+			Name:         "okta",
+			OAuthVersion: OAuthVersion2,
+			Issuer:       "https://{domain}/oauth2/default",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims:       ClaimMapping{ScreenName: []string{"preferred_username"}},
+		},
+		"auth0": {
+			Name:         "auth0",
+			OAuthVersion: OAuthVersion2,
+			Issuer:       "https://{domain}/",
+			Scopes:       []string{"openid", "profile", "email"},
+			Claims: ClaimMapping{
+				ScreenName: []string{"nickname"},
+				PhotoURL:   []string{"picture"},
+			},
+		},
+	}
+)
+
+// LookupPreset returns the preset with the given name, ignoring case.
+func LookupPreset(name string) (Preset, bool) {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+	preset, found := presets[strings.ToLower(name)]
+	return preset, found
+}
+
+// RegisterPreset adds a preset, or replaces the built-in preset with the same
+// name, making it available to the configuration files.
+func RegisterPreset(preset Preset) {
+	presetsMutex.Lock()
+	defer presetsMutex.Unlock()
+	preset.Name = strings.ToLower(preset.Name)
+	presets[preset.Name] = preset
+}
+
+// PresetNames returns the sorted names of the registered presets.
+func PresetNames() []string {
+	presetsMutex.RLock()
+	defer presetsMutex.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// OAuth1Config returns the configuration of an OAuth 1.0 provider using this
+// preset.
+func (p Preset) OAuth1Config(clientID, clientSecret, redirectURL string) OAuth1ServiceProviderConfig {
+	return OAuth1ServiceProviderConfig{
+		ProviderName:     p.Name,
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		AuthURL:          p.expand(p.AuthURL),
+		TokenURL:         p.expand(p.TokenURL),
+		UserInfoURL:      p.expand(p.UserInfoURL),
+		RequestTokenURL:  p.expand(p.RequestTokenURL),
+		RedirectURL:      redirectURL,
+		Claims:           p.Claims,
+		UserInfoRequests: p.userInfoRequests(),
+	}
+}
+
+// OAuth2Config returns the configuration of an OAuth 2.0 provider using this
+// preset.
+func (p Preset) OAuth2Config(clientID, clientSecret, redirectURL string) OAuth2ServiceProviderConfig {
+	return OAuth2ServiceProviderConfig{
+		ProviderName:     p.Name,
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		AuthURL:          p.expand(p.AuthURL),
+		TokenURL:         p.expand(p.TokenURL),
+		UserInfoURL:      p.expand(p.UserInfoURL),
+		Issuer:           p.expand(p.Issuer),
+		RedirectURL:      redirectURL,
+		Scopes:           append([]string(nil), p.Scopes...),
+		Claims:           p.Claims,
+		UserInfoRequests: p.userInfoRequests(),
+	}
+}
+
+// NewServiceProvider creates a provider using this preset.
+func (p Preset) NewServiceProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	if p.OAuthVersion == OAuthVersion1 {
+		return NewOAuth1ServiceProvider(p.OAuth1Config(clientID, clientSecret, redirectURL))
+	}
+	return NewOAuth2ServiceProvider(p.OAuth2Config(clientID, clientSecret, redirectURL))
+}
+
+// validate reports a domain or tenant which is needed by the URLs but not set.
+func (p Preset) validate() error {
+	urls := []string{p.AuthURL, p.TokenURL, p.UserInfoURL, p.RequestTokenURL, p.Issuer}
+	for _, request := range p.UserInfoRequests {
+		urls = append(urls, request.URL)
+	}
+	for _, u := range urls {
+		if strings.Contains(u, presetDomain) && len(p.Domain) == 0 {
+			return fmt.Errorf("The preset %v requires a Domain.", p.Name)
+		}
+		if strings.Contains(u, presetTenant) && len(p.Tenant) == 0 {
+			return fmt.Errorf("The preset %v requires a Tenant.", p.Name)
+		}
+	}
+	return nil
+}
+
+// userInfoRequests returns a copy of the user information requests with the
+// placeholders replaced.
+func (p Preset) userInfoRequests() []UserInfoRequest {
+	var requests []UserInfoRequest
+	for _, request := range p.UserInfoRequests {
+		requests = append(requests, UserInfoRequest{URL: p.expand(request.URL), Claim: request.Claim})
+	}
+	return requests
+}
+
+func (p Preset) expand(u string) string {
+	return strings.NewReplacer(presetDomain, p.Domain, presetTenant, p.Tenant).Replace(u)
+}
+
+// newPresetProvider creates a provider using a built-in preset.
+func newPresetProvider(name, domain, tenant, clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	preset, _ := LookupPreset(name)
+	if len(domain) > 0 {
+		preset.Domain = domain
+	}
+	if len(tenant) > 0 {
+		preset.Tenant = tenant
+	}
+	return preset.NewServiceProvider(clientID, clientSecret, redirectURL)
+}
+
+// newDomainPresetProvider creates a provider using a built-in preset which has no
+// default domain, returning an error if the domain is empty.
+func newDomainPresetProvider(name, domain, clientID, clientSecret, redirectURL string) (OAuthServiceProvider, error) {
+	preset, _ := LookupPreset(name)
+	preset.Domain = domain
+	if err := preset.validate(); err != nil {
+		return nil, err
+	}
+	return preset.NewServiceProvider(clientID, clientSecret, redirectURL), nil
+}
+
+// NewGoogleProvider creates a Google provider.
+func NewGoogleProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("google", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewGitHubProvider creates a GitHub provider.
+func NewGitHubProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("github", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewGitLabProvider creates a GitLab provider. The domain of a self-hosted
+// GitLab may be given, otherwise gitlab.com is used.
+func NewGitLabProvider(domain, clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("gitlab", domain, "", clientID, clientSecret, redirectURL)
+}
+
+// NewMicrosoftProvider creates a Microsoft (Azure AD) provider. The tenant may be
+// a tenant id or domain, "organizations", "consumers" or "common", which is used
+// when the tenant is empty.
+func NewMicrosoftProvider(tenant, clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("microsoft", "", tenant, clientID, clientSecret, redirectURL)
+}
+
+// NewFacebookProvider creates a Facebook provider.
+func NewFacebookProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("facebook", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewTwitterProvider creates a Twitter OAuth 1.0 provider.
+func NewTwitterProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("twitter", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewLinkedInProvider creates a LinkedIn provider.
+func NewLinkedInProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("linkedin", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewSlackProvider creates a Slack provider.
+func NewSlackProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("slack", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewDiscordProvider creates a Discord provider.
+func NewDiscordProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("discord", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewBitbucketProvider creates a Bitbucket provider.
+func NewBitbucketProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("bitbucket", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewAppleProvider creates a Sign in with Apple provider. The client secret is
+// the signed JWT created with the key downloaded from Apple.
+func NewAppleProvider(clientID, clientSecret, redirectURL string) OAuthServiceProvider {
+	return newPresetProvider("apple", "", "", clientID, clientSecret, redirectURL)
+}
+
+// NewOktaProvider creates an Okta provider using the default authorization
+// server of the Okta domain (eg: dev-123456.okta.com). An error is returned if
+// the domain is empty.
+func NewOktaProvider(domain, clientID, clientSecret, redirectURL string) (OAuthServiceProvider, error) {
+	return newDomainPresetProvider("okta", domain, clientID, clientSecret, redirectURL)
+}
+
+// NewAuth0Provider creates an Auth0 provider for the Auth0 domain (eg:
+// example.us.auth0.com). An error is returned if the domain is empty.
+func NewAuth0Provider(domain, clientID, clientSecret, redirectURL string) (OAuthServiceProvider, error) {
+	return newDomainPresetProvider("auth0", domain, clientID, clientSecret, redirectURL)
+}
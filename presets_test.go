@@ -0,0 +1,179 @@
+package goauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func ExampleConfigureProvidersFromJSON_preset() {
+	jsonString := `{
+   "GitHub":{"Preset":"github","ClientID":"abc123","ClientSecret":"xyz456"},
+   "Work":{"Preset":"okta","Domain":"example.okta.com","ClientID":"abc123","ClientSecret":"xyz456"},
+   "Twitter":{"Preset":"twitter","ClientID":"abc123","ClientSecret":"xyz456"}
+}`
+
+	providers, err := ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	for _, name := range []string{"github", "twitter", "work"} {
+		fmt.Printf("The provider for %s is a version %s provider named %s.\n", name,
+			providers[name].GetOAuthVersion(), providers[name].GetProviderName())
+	}
+	// Output:
+	// The provider for github is a version 2.0 provider named GITHUB.
+	// The provider for twitter is a version 1.0 provider named TWITTER.
+	// The provider for work is a version 2.0 provider named WORK.
+}
+
+func TestPresetConstructors(t *testing.T) {
+	constructors := map[string]OAuthServiceProvider{
+		"GOOGLE":    NewGoogleProvider("id", "secret", "http://myhost/callback"),
+		"GITHUB":    NewGitHubProvider("id", "secret", "http://myhost/callback"),
+		"GITLAB":    NewGitLabProvider("", "id", "secret", "http://myhost/callback"),
+		"MICROSOFT": NewMicrosoftProvider("", "id", "secret", "http://myhost/callback"),
+		"FACEBOOK":  NewFacebookProvider("id", "secret", "http://myhost/callback"),
+		"TWITTER":   NewTwitterProvider("id", "secret", "http://myhost/callback"),
+		"LINKEDIN":  NewLinkedInProvider("id", "secret", "http://myhost/callback"),
+		"SLACK":     NewSlackProvider("id", "secret", "http://myhost/callback"),
+		"DISCORD":   NewDiscordProvider("id", "secret", "http://myhost/callback"),
+		"BITBUCKET": NewBitbucketProvider("id", "secret", "http://myhost/callback"),
+		"APPLE":     NewAppleProvider("id", "secret", "http://myhost/callback"),
+	}
+	var err error
+	if constructors["OKTA"], err = NewOktaProvider("example.okta.com", "id", "secret", "http://myhost/callback"); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if constructors["AUTH0"], err = NewAuth0Provider("example.auth0.com", "id", "secret", "http://myhost/callback"); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	for name, provider := range constructors {
+		if provider.GetProviderName() != name {
+			t.Logf("Expecting %v but found %v.", name, provider.GetProviderName())
+			t.Fail()
+		}
+	}
+	if len(constructors) != len(PresetNames()) {
+		t.Logf("Expecting a constructor for each of the presets %v.", PresetNames())
+		t.Fail()
+	}
+
+	gitlab := NewGitLabProvider("git.example.com", "id", "secret", "http://myhost/callback").(*OAuth2ServiceProvider)
+	if gitlab.userInfoURL != "https://git.example.com/api/v4/user" {
+		t.Logf("Invalid GitLab user info URL %v.", gitlab.userInfoURL)
+		t.Fail()
+	}
+	microsoft := NewMicrosoftProvider("", "id", "secret", "http://myhost/callback").(*OAuth2ServiceProvider)
+	if microsoft.conf.Endpoint.AuthURL != "https://login.microsoftonline.com/common/oauth2/v2.0/authorize" {
+		t.Logf("Invalid Microsoft auth URL %v.", microsoft.conf.Endpoint.AuthURL)
+		t.Fail()
+	}
+	if okta := constructors["OKTA"].(*OAuth2ServiceProvider); okta.oidc == nil || okta.claims.UserID[0] != "sub" {
+		t.Log("Expecting Okta to be an OpenID Connect provider.")
+		t.Fail()
+	}
+}
+
+func TestPresetConstructorsRequireDomain(t *testing.T) {
+	constructors := map[string]func(domain, clientID, clientSecret, redirectURL string) (OAuthServiceProvider, error){
+		"okta":  NewOktaProvider,
+		"auth0": NewAuth0Provider,
+	}
+	for name, constructor := range constructors {
+		if _, err := constructor("", "id", "secret", "http://myhost/callback"); err == nil || err.Error() != fmt.Sprintf("The preset %v requires a Domain.", name) {
+			t.Logf("Expecting %v to require a domain but found %v.", name, err)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigurePresets(t *testing.T) {
+	yamlString := `Azure:
+  Preset:       Microsoft
+  Tenant:       contoso.onmicrosoft.com
+  ClientID:     abc123
+  ClientSecret: xyz456
+  Scopes:       openid
+  Claims:
+    Email: preferred_username`
+	providers, err := ConfigureProvidersFromYAML(strings.NewReader(yamlString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	azure := providers["azure"].(*OAuth2ServiceProvider)
+	if azure.conf.Endpoint.TokenURL != "https://login.microsoftonline.com/contoso.onmicrosoft.com/oauth2/v2.0/token" ||
+		len(azure.conf.Scopes) != 1 || azure.conf.RedirectURL != "http://myhost/oauth/callback/azure" {
+		t.Logf("Invalid configuration %v.", azure.conf)
+		t.Fail()
+	}
+	if azure.claims.Email[0] != "preferred_username" || azure.claims.UserID[0] != "sub" {
+		t.Logf("Expecting the claims of the preset to be overridden but found %v.", azure.claims)
+		t.Fail()
+	}
+
+	invalid := []string{
+		`{"Test":{"Preset":"myspace","ClientID":"abc123","ClientSecret":"xyz456"}}`,
+		`{"Test":{"Preset":"okta","ClientID":"abc123","ClientSecret":"xyz456"}}`,
+		`{"Test":{"Preset":"twitter","OAuthVersion":2.0,"ClientID":"abc123","ClientSecret":"xyz456"}}`,
+	}
+	for _, jsonString := range invalid {
+		if _, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil {
+			t.Logf("Expecting an error configuring %v.", jsonString)
+			t.Fail()
+		}
+	}
+}
+
+func TestRegisterPreset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"abc","token_type":"Bearer"}`))
+		case "/user/emails":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"values":[{"email":"old@example.com","is_primary":false,"is_confirmed":false},{"email":"jdoe@example.com","is_primary":true,"is_confirmed":true}]}`))
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"uuid":"{1234}","login":"jdoe","links":{"avatar":{"href":"https://example.com/jdoe.png"}}}`))
+		}
+	}))
+	defer server.Close()
+
+	preset, _ := LookupPreset("bitbucket")
+	preset.Name = "Test-Bitbucket"
+	preset.TokenURL = server.URL + "/token"
+	preset.UserInfoURL = server.URL + "/user"
+	preset.UserInfoRequests = []UserInfoRequest{{URL: server.URL + "/user/emails", Claim: "emails"}}
+	preset.Claims.ScreenName = []string{"login"}
+	RegisterPreset(preset)
+	defer func() {
+		presetsMutex.Lock()
+		delete(presets, "test-bitbucket")
+		presetsMutex.Unlock()
+	}()
+
+	jsonString := `{"Test":{"Preset":"TEST-BITBUCKET","ClientID":"abc123","ClientSecret":"xyz456","AllowUnboundState":true}}`
+	providers, err := ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	provider := providers["test"].(*OAuth2ServiceProvider)
+	stateFlag, _ := provider.generateStateFlag(context.Background())
+	user, err := provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/test?code=xyz&state="+url.QueryEscape(stateFlag), nil))
+	if err != nil || user.UserID != "{1234}" || user.ScreenName != "jdoe" || user.PhotoURL != "https://example.com/jdoe.png" ||
+		user.Email != "jdoe@example.com" || !user.EmailVerified || len(user.Emails) != 2 {
+		t.Logf("Invalid user %v (%v).", user, err)
+		t.Fail()
+	}
+}
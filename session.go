@@ -0,0 +1,367 @@
+package goauth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionDefaultCookieName = "goauth_session"
+	sessionDefaultMaxAge     = 86400
+	sessionEncryptionLabel   = "goauth session encryption"
+	sessionSignatureLabel    = "goauth session signature"
+
+	// browsers ignore cookies larger than 4096 bytes, including the name and
+	// attributes
+	sessionMaxCookieSize = 4096
+)
+
+// ErrNoSession is returned when a request does not have a valid session.
+var ErrNoSession = errors.New("No valid session found in the request.")
+
+// ErrSessionTooLarge is returned when a cookie session is larger than browsers
+// accept. Use a server side session store for users with large tokens.
+var ErrSessionTooLarge = errors.New("The session is too large to store in a cookie.")
+
+// SessionStore keeps the authenticated user between requests once the
+// authentication process has completed.
+type SessionStore interface {
+	// Save starts a new session for the user, replacing any current session.
+	Save(w http.ResponseWriter, r *http.Request, user UserData) error
+
+	// Load returns the user of the request's current session, or ErrNoSession if
+	// there is no session or the session has expired.
+	Load(r *http.Request) (UserData, error)
+
+	// Clear ends the request's current session, logging the user out.
+	Clear(w http.ResponseWriter, r *http.Request) error
+}
+
+// SessionConfig is a simple struct which can be used to initialize a SessionStore.
+type SessionConfig struct {
+
+	// CookieName is the name of the session cookie. Defaults to goauth_session.
+	CookieName string
+
+	// MaxAgeSeconds is how long a session lasts. Defaults to one day.
+	MaxAgeSeconds int
+
+	// Path is the cookie path. Defaults to "/".
+	Path string
+
+	// Domain is the cookie domain. Defaults to the host of the request.
+	Domain string
+
+	// Secure restricts the cookie to HTTPS connections.
+	Secure bool
+
+	// SameSite is the cookie's same site mode. Defaults to http.SameSiteLaxMode.
+	SameSite http.SameSite
+
+	// Keys are the secret keys used to sign and encrypt cookie sessions. The first
+	// key is used for new sessions, the others are still accepted so that keys can
+	// be rotated without logging every user out. Only used by cookie sessions.
+	Keys [][]byte
+}
+
+// sessionData is the serialized form of a session.
+type sessionData struct {
+	User    UserData `json:"user"`
+	Expires int64    `json:"expires"`
+}
+
+func (config SessionConfig) withDefaults() SessionConfig {
+	if len(config.CookieName) == 0 {
+		config.CookieName = sessionDefaultCookieName
+	}
+	if config.MaxAgeSeconds < 1 {
+		config.MaxAgeSeconds = sessionDefaultMaxAge
+	}
+	if len(config.Path) == 0 {
+		config.Path = "/"
+	}
+	if config.SameSite == 0 {
+		config.SameSite = http.SameSiteLaxMode
+	}
+	return config
+}
+
+func (config SessionConfig) setCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, config.cookie(value, maxAge))
+}
+
+func (config SessionConfig) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     config.CookieName,
+		Value:    value,
+		Path:     config.Path,
+		Domain:   config.Domain,
+		MaxAge:   maxAge,
+		Secure:   config.Secure,
+		HttpOnly: true,
+		SameSite: config.SameSite,
+	}
+}
+
+// NewCookieSessionStore creates a SessionStore which keeps the whole session in
+// an AES-GCM encrypted and HMAC-SHA256 signed cookie. Browsers limit cookies to
+// 4KB, which the user's tokens can exceed, so Save returns ErrSessionTooLarge
+// rather than a cookie the browser would drop; use a server side session store
+// if that is a problem.
+func NewCookieSessionStore(config SessionConfig) (SessionStore, error) {
+	if len(config.Keys) == 0 {
+		return nil, errors.New("At least one key is required for cookie sessions.")
+	}
+	store := &cookieSessionStore{config: config.withDefaults()}
+	for _, key := range config.Keys {
+		if len(key) < 16 {
+			return nil, errors.New("Cookie session keys must be at least 16 bytes.")
+		}
+		block, err := aes.NewCipher(deriveKey(key, sessionEncryptionLabel))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		store.keys = append(store.keys, sessionKey{aead: aead, signingKey: deriveKey(key, sessionSignatureLabel)})
+	}
+	return store, nil
+}
+
+type sessionKey struct {
+	aead       cipher.AEAD
+	signingKey []byte
+}
+
+type cookieSessionStore struct {
+	config SessionConfig
+	keys   []sessionKey
+}
+
+func (s *cookieSessionStore) Save(w http.ResponseWriter, r *http.Request, user UserData) error {
+	data, err := json.Marshal(sessionData{
+		User:    user,
+		Expires: time.Now().Add(time.Duration(s.config.MaxAgeSeconds) * time.Second).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	key := s.keys[0]
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return err
+	}
+	encrypted := key.aead.Seal(nonce, nonce, data, []byte(s.config.CookieName))
+	value := base64.RawURLEncoding.EncodeToString(encrypted)
+	value = value + "." + base64.RawURLEncoding.EncodeToString(s.sign(key, value))
+	cookie := s.config.cookie(value, s.config.MaxAgeSeconds)
+	if len(cookie.String()) > sessionMaxCookieSize {
+		return ErrSessionTooLarge
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+func (s *cookieSessionStore) Load(r *http.Request) (UserData, error) {
+	cookie, err := r.Cookie(s.config.CookieName)
+	if err != nil {
+		return UserData{}, ErrNoSession
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 {
+		return UserData{}, ErrNoSession
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return UserData{}, ErrNoSession
+	}
+	encrypted, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return UserData{}, ErrNoSession
+	}
+	for _, key := range s.keys {
+		if !hmac.Equal(signature, s.sign(key, parts[0])) || len(encrypted) < key.aead.NonceSize() {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		data, err := key.aead.Open(nil, encrypted[:nonceSize], encrypted[nonceSize:], []byte(s.config.CookieName))
+		if err != nil {
+			continue
+		}
+		return decodeSession(data)
+	}
+	return UserData{}, ErrNoSession
+}
+
+func (s *cookieSessionStore) Clear(w http.ResponseWriter, r *http.Request) error {
+	s.config.setCookie(w, "", -1)
+	return nil
+}
+
+func (s *cookieSessionStore) sign(key sessionKey, value string) []byte {
+	mac := hmac.New(sha256.New, key.signingKey)
+	mac.Write([]byte(s.config.CookieName + "=" + value))
+	return mac.Sum(nil)
+}
+
+// SessionBackend stores serialized sessions on the server for a server side
+// SessionStore. Implementations might use a database or a cache such as Redis.
+type SessionBackend interface {
+	// Get returns the session data stored under the id, or ErrNoSession.
+	Get(id string) ([]byte, error)
+
+	// Set stores the session data under the id until the expiry time.
+	Set(id string, data []byte, expiry time.Time) error
+
+	// Delete removes the session stored under the id.
+	Delete(id string) error
+}
+
+// NewServerSessionStore creates a SessionStore which keeps sessions in the backend,
+// storing only a random session id in the cookie.
+func NewServerSessionStore(backend SessionBackend, config SessionConfig) SessionStore {
+	return &serverSessionStore{backend: backend, config: config.withDefaults()}
+}
+
+type serverSessionStore struct {
+	backend SessionBackend
+	config  SessionConfig
+}
+
+func (s *serverSessionStore) Save(w http.ResponseWriter, r *http.Request, user UserData) error {
+	// never reuse an existing session id after authenticating
+	if cookie, err := r.Cookie(s.config.CookieName); err == nil {
+		s.backend.Delete(cookie.Value)
+	}
+	id, err := randomString(32)
+	if err != nil {
+		return err
+	}
+	expiry := time.Now().Add(time.Duration(s.config.MaxAgeSeconds) * time.Second)
+	data, err := json.Marshal(sessionData{User: user, Expires: expiry.Unix()})
+	if err != nil {
+		return err
+	}
+	if err = s.backend.Set(id, data, expiry); err != nil {
+		return err
+	}
+	s.config.setCookie(w, id, s.config.MaxAgeSeconds)
+	return nil
+}
+
+func (s *serverSessionStore) Load(r *http.Request) (UserData, error) {
+	cookie, err := r.Cookie(s.config.CookieName)
+	if err != nil {
+		return UserData{}, ErrNoSession
+	}
+	data, err := s.backend.Get(cookie.Value)
+	if err != nil {
+		return UserData{}, err
+	}
+	return decodeSession(data)
+}
+
+func (s *serverSessionStore) Clear(w http.ResponseWriter, r *http.Request) error {
+	s.config.setCookie(w, "", -1)
+	if cookie, err := r.Cookie(s.config.CookieName); err == nil {
+		return s.backend.Delete(cookie.Value)
+	}
+	return nil
+}
+
+// NewMemorySessionBackend creates an in memory SessionBackend. Sessions are lost
+// when the process exits and are not shared between instances.
+func NewMemorySessionBackend() SessionBackend {
+	return &memorySessionBackend{sessions: make(map[string]memorySession), mutex: &sync.Mutex{}}
+}
+
+type memorySession struct {
+	data   []byte
+	expiry time.Time
+}
+
+type memorySessionBackend struct {
+	sessions  map[string]memorySession
+	lastSweep time.Time
+	mutex     *sync.Mutex
+}
+
+func (b *memorySessionBackend) Get(id string) ([]byte, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	session, found := b.sessions[id]
+	if !found || session.expiry.Before(time.Now()) {
+		return nil, ErrNoSession
+	}
+	return session.data, nil
+}
+
+func (b *memorySessionBackend) Set(id string, data []byte, expiry time.Time) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// remove expired sessions at most once a minute
+	if now := time.Now(); now.Sub(b.lastSweep) > time.Minute {
+		for key, session := range b.sessions {
+			if session.expiry.Before(now) {
+				delete(b.sessions, key)
+			}
+		}
+		b.lastSweep = now
+	}
+	b.sessions[id] = memorySession{data: data, expiry: expiry}
+	return nil
+}
+
+func (b *memorySessionBackend) Delete(id string) error {
+	b.mutex.Lock()
+	delete(b.sessions, id)
+	b.mutex.Unlock()
+	return nil
+}
+
+// LogoutHandler returns an http.Handler which ends the current session and
+// redirects the user to redirectURL. It ends the session for any request method,
+// so only route POST requests to it, or any cross site link can log the user out.
+func LogoutHandler(store SessionStore, redirectURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := store.Clear(w, r); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+	})
+}
+
+func decodeSession(data []byte) (UserData, error) {
+	session := sessionData{}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return UserData{}, fmt.Errorf("Could not decode session: %v.", err)
+	}
+	if session.Expires < time.Now().Unix() {
+		return UserData{}, ErrNoSession
+	}
+	if session.User.Raw != nil {
+		session.User.RawJSON, _ = json.Marshal(session.User.Raw)
+	}
+	return session.User, nil
+}
+
+// deriveKey derives a 256 bit key for a single purpose from a secret key.
+func deriveKey(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
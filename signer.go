@@ -0,0 +1,175 @@
+package goauth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+)
+
+// The OAuth 1.0 signature methods supported by the OAuth1ServiceProvider.
+const (
+	SignatureMethodHMACSHA1   = "HMAC-SHA1"
+	SignatureMethodHMACSHA256 = "HMAC-SHA256"
+	SignatureMethodRSASHA1    = "RSA-SHA1"
+	SignatureMethodPlaintext  = "PLAINTEXT"
+)
+
+// Signer signs OAuth 1.0 requests. Implement it to support signature methods
+// which are not built in.
+type Signer interface {
+	// Name returns the signature method, sent as the oauth_signature_method
+	// parameter (eg: HMAC-SHA1).
+	Name() string
+
+	// Sign returns the signature of the base string, given the consumer secret and
+	// the token secret. The token secret is empty when there is no token yet.
+	Sign(baseString, consumerSecret, tokenSecret string) (string, error)
+}
+
+// NewHMACSHA1Signer creates a Signer implementing the HMAC-SHA1 signature method.
+func NewHMACSHA1Signer() Signer {
+	return &hmacSigner{name: SignatureMethodHMACSHA1, hash: sha1.New}
+}
+
+// NewHMACSHA256Signer creates a Signer implementing the HMAC-SHA256 signature
+// method.
+func NewHMACSHA256Signer() Signer {
+	return &hmacSigner{name: SignatureMethodHMACSHA256, hash: sha256.New}
+}
+
+// NewPlaintextSigner creates a Signer implementing the PLAINTEXT signature method,
+// which sends the secrets as the signature and must only be used over TLS.
+func NewPlaintextSigner() Signer {
+	return &plaintextSigner{}
+}
+
+// NewRSASHA1Signer creates a Signer implementing the RSA-SHA1 signature method
+// using the consumer's private key. The consumer and token secrets are not used.
+func NewRSASHA1Signer(privateKey *rsa.PrivateKey) Signer {
+	return &rsaSigner{privateKey: privateKey}
+}
+
+type hmacSigner struct {
+	name string
+	hash func() hash.Hash
+}
+
+func (s *hmacSigner) Name() string {
+	return s.name
+}
+
+func (s *hmacSigner) Sign(baseString, consumerSecret, tokenSecret string) (string, error) {
+	mac := hmac.New(s.hash, []byte(signingKey(consumerSecret, tokenSecret)))
+	mac.Write([]byte(baseString))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+type plaintextSigner struct{}
+
+func (s *plaintextSigner) Name() string {
+	return SignatureMethodPlaintext
+}
+
+func (s *plaintextSigner) Sign(baseString, consumerSecret, tokenSecret string) (string, error) {
+	return signingKey(consumerSecret, tokenSecret), nil
+}
+
+type rsaSigner struct {
+	privateKey *rsa.PrivateKey
+}
+
+func (s *rsaSigner) Name() string {
+	return SignatureMethodRSASHA1
+}
+
+func (s *rsaSigner) Sign(baseString, consumerSecret, tokenSecret string) (string, error) {
+	hashed := sha1.Sum([]byte(baseString))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.privateKey, crypto.SHA1, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// failedSigner is used when the configured signer could not be created, so that
+// every request fails with the reason.
+type failedSigner struct {
+	name string
+	err  error
+}
+
+func (s *failedSigner) Name() string {
+	return s.name
+}
+
+func (s *failedSigner) Sign(baseString, consumerSecret, tokenSecret string) (string, error) {
+	return "", s.err
+}
+
+// signingKey is the key described in RFC 5849 section 3.4.2.
+func signingKey(consumerSecret, tokenSecret string) string {
+	return percentEncode(consumerSecret) + "&" + percentEncode(tokenSecret)
+}
+
+// newSigner creates the Signer for the configuration, defaulting to HMAC-SHA1.
+func newSigner(config OAuth1ServiceProviderConfig) (Signer, error) {
+	if config.Signer != nil {
+		return config.Signer, nil
+	}
+	switch strings.ToUpper(config.SignatureMethod) {
+	case "", SignatureMethodHMACSHA1:
+		return NewHMACSHA1Signer(), nil
+	case SignatureMethodHMACSHA256:
+		return NewHMACSHA256Signer(), nil
+	case SignatureMethodPlaintext:
+		return NewPlaintextSigner(), nil
+	case SignatureMethodRSASHA1:
+		keyData := []byte(config.PrivateKey)
+		if len(keyData) == 0 && len(config.PrivateKeyFile) > 0 {
+			var err error
+			if keyData, err = ioutil.ReadFile(config.PrivateKeyFile); err != nil {
+				return nil, fmt.Errorf("Could not read the private key for the provider %s: %v.", config.ProviderName, err)
+			}
+		}
+		if len(keyData) == 0 {
+			return nil, fmt.Errorf("No private key could be found for the provider %s.", config.ProviderName)
+		}
+		privateKey, err := parseRSAPrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse the private key for the provider %s: %v.", config.ProviderName, err)
+		}
+		return NewRSASHA1Signer(privateKey), nil
+	}
+	return nil, fmt.Errorf("Unsupported signature method %v for the provider %s.", config.SignatureMethod, config.ProviderName)
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #1 or PKCS #8 RSA private key.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
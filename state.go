@@ -0,0 +1,73 @@
+package goauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+)
+
+const (
+	stateCookieName = "goauth_state"
+	stateKeyFlag    = "state:"
+)
+
+type stateBindingKey struct{}
+
+// the key used to sign state flags by providers which are not configured with
+// their own. Servers running more than one instance must configure a shared key.
+var defaultStateKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// BindState binds the state flags created with the returned context to the
+// user's browser, protecting against login cross site request forgery. A random
+// value is stored in a cookie, which must be sent back with the provider's
+// response for ProcessResponse to accept the state flag. Pass the returned
+// context to GetRedirectURLContext. The Handler does this automatically.
+func BindState(w http.ResponseWriter, r *http.Request) (context.Context, error) {
+	if cookie, err := r.Cookie(stateCookieName); err == nil && len(cookie.Value) > 0 {
+		return withStateBinding(r.Context(), cookie.Value), nil
+	}
+	binding, err := randomString(32)
+	if err != nil {
+		return r.Context(), err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    binding,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// the provider's redirect back is a top level navigation, which lax allows
+		SameSite: http.SameSiteLaxMode,
+	})
+	return withStateBinding(r.Context(), binding), nil
+}
+
+func withStateBinding(ctx context.Context, binding string) context.Context {
+	return context.WithValue(ctx, stateBindingKey{}, binding)
+}
+
+func stateBindingFromContext(ctx context.Context) string {
+	binding, _ := ctx.Value(stateBindingKey{}).(string)
+	return binding
+}
+
+func stateBindingFromRequest(r *http.Request) string {
+	if cookie, err := r.Cookie(stateCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func signState(key []byte, payload, binding string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload + "|" + binding))
+	return mac.Sum(nil)
+}
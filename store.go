@@ -0,0 +1,355 @@
+package goauth
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// key prefixes used to keep the values stored by each part of the library apart.
+const (
+	stateKeyOAuth1Token = "oauth1:"
+	stateKeyPKCE        = "pkce:"
+	stateKeyNonce       = "nonce:"
+	stateKeyOAuth1Nonce = "oauth1nonce:"
+)
+
+// the capacity and the time to live of the in memory store each provider creates
+// when it is not configured with a store.
+const (
+	defaultStateStoreCapacity      = 10000
+	defaultStateStoreMaxAgeSeconds = 300
+)
+
+// StateStore holds the short lived values created when a user begins to
+// authenticate, such as OAuth 1.0 request token secrets, OAuth 2.0 PKCE code
+// verifiers and OpenID Connect nonces, until the provider sends the user back.
+// When running more than one instance of a server behind a load balancer, the
+// callback may reach a different instance than the one which created the value,
+// so the instances must share a store.
+type StateStore interface {
+	// Put stores a value under the given key.
+	Put(key, value string) error
+
+	// Get retrieves the value stored under the given key, returning an error if
+	// there is no value or the value has expired.
+	Get(key string) (string, error)
+
+	// Delete removes the value stored under the given key.
+	Delete(key string) error
+}
+
+// AtomicStateStore is a StateStore which can remove and return a value, or add
+// a value which is not there yet, in a single step, so that two requests racing
+// with the same state flag or nonce cannot both use it. The stores created by
+// this package implement it. Other stores fall back to Get followed by Delete or
+// Put.
+type AtomicStateStore interface {
+	StateStore
+
+	// Take removes and returns the value stored under the given key, returning an
+	// error if there is no value or the value has expired.
+	Take(key string) (string, error)
+
+	// Add stores a value under the given key unless there is already a value which
+	// has not expired, in which case it returns false.
+	Add(key, value string) (bool, error)
+}
+
+// takeState removes and returns a single use value from the store.
+func takeState(store StateStore, key string) (string, error) {
+	if atomic, ok := store.(AtomicStateStore); ok {
+		return atomic.Take(key)
+	}
+	value, err := store.Get(key)
+	if err != nil {
+		return "", err
+	}
+	return value, store.Delete(key)
+}
+
+// addState stores a value unless the key is already in the store.
+func addState(store StateStore, key, value string) (bool, error) {
+	if atomic, ok := store.(AtomicStateStore); ok {
+		return atomic.Add(key, value)
+	}
+	if _, err := store.Get(key); err == nil {
+		return false, nil
+	}
+	return true, store.Put(key, value)
+}
+
+// NewMemoryStateStore creates an in memory StateStore backed by an LRU cache.
+// Values expire maxAgeSeconds after they are stored. Once the capacity is
+// reached the expired values are removed, or else the least recently used value,
+// so storing a value never fails. This is the default store, which each provider
+// creates for itself.
+func NewMemoryStateStore(capacity, maxAgeSeconds int) StateStore {
+	return newTokenCache(capacity, maxAgeSeconds)
+}
+
+// NewFileStateStore creates a StateStore which keeps each value in a file in the
+// given directory, which may be on a volume shared between instances. Values
+// older than maxAge are treated as missing and eventually removed.
+func NewFileStateStore(dir string, maxAge time.Duration) (StateStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &fileStateStore{dir: dir, maxAge: maxAge, mutex: &sync.Mutex{}}, nil
+}
+
+type fileStateStore struct {
+	dir       string
+	maxAge    time.Duration
+	lastSweep time.Time
+	mutex     *sync.Mutex
+}
+
+func (s *fileStateStore) Put(key, value string) error {
+	tmp, err := s.writeTemp(value)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path(key)); err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Add links the value into place, which fails when the file already exists.
+func (s *fileStateStore) Add(key, value string) (bool, error) {
+	tmp, err := s.writeTemp(value)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp)
+	path := s.path(key)
+	if err = os.Link(tmp, path); os.IsExist(err) {
+		if _, err = s.Get(key); err == nil {
+			return false, nil
+		}
+		// the value has expired and was removed by Get
+		err = os.Link(tmp, path)
+	}
+	if os.IsExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// writeTemp writes the value to a temporary file first, so readers never see a
+// partial value.
+func (s *fileStateStore) writeTemp(value string) (string, error) {
+	s.sweep()
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	if _, err = tmp.WriteString(value); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (s *fileStateStore) Get(key string) (string, error) {
+	path := s.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("Could not find state for %v.", key)
+	}
+	if time.Since(info.ModTime()) > s.maxAge {
+		os.Remove(path)
+		return "", fmt.Errorf("The state for %v has expired.", key)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (s *fileStateStore) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Take renames the file before reading it, so only one caller can take a value.
+func (s *fileStateStore) Take(key string) (string, error) {
+	suffix, err := randomString(12)
+	if err != nil {
+		return "", err
+	}
+	path := s.path(key) + ".taken-" + suffix
+	if err = os.Rename(s.path(key), path); err != nil {
+		return "", fmt.Errorf("Could not find state for %v.", key)
+	}
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if time.Since(info.ModTime()) > s.maxAge {
+		return "", fmt.Errorf("The state for %v has expired.", key)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// keys are hashed so any value can safely be used as a file name.
+func (s *fileStateStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// sweep removes expired files, at most once per maxAge.
+func (s *fileStateStore) sweep() {
+	s.mutex.Lock()
+	if time.Since(s.lastSweep) < s.maxAge {
+		s.mutex.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mutex.Unlock()
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() && time.Since(file.ModTime()) > s.maxAge {
+			os.Remove(filepath.Join(s.dir, file.Name()))
+		}
+	}
+}
+
+// SQLStateStoreConfig is a simple struct which can be used to initialize a
+// StateStore backed by a database. The table must have the following layout:
+//
+//	CREATE TABLE goauth_state (
+//	    state_key   VARCHAR(255) PRIMARY KEY,
+//	    state_value TEXT NOT NULL,
+//	    created_at  BIGINT NOT NULL
+//	)
+type SQLStateStoreConfig struct {
+
+	// Table is the name of the table holding the state. Defaults to goauth_state.
+	Table string
+
+	// MaxAgeSeconds is how long values are kept. Defaults to 300.
+	MaxAgeSeconds int
+
+	// Placeholder is the bind parameter style used by the database driver, either
+	// "?" (MySQL, SQLite) or "$" (PostgreSQL). Defaults to "?".
+	Placeholder string
+}
+
+// NewSQLStateStore creates a StateStore which keeps values in a database table
+// using database/sql.
+func NewSQLStateStore(db *sql.DB, config SQLStateStoreConfig) StateStore {
+	if len(config.Table) == 0 {
+		config.Table = "goauth_state"
+	}
+	if config.MaxAgeSeconds < 1 {
+		config.MaxAgeSeconds = 300
+	}
+	return &sqlStateStore{db: db, config: config}
+}
+
+type sqlStateStore struct {
+	db     *sql.DB
+	config SQLStateStoreConfig
+}
+
+func (s *sqlStateStore) Put(key, value string) error {
+	now := time.Now().Unix()
+	_, err := s.db.Exec(s.query("DELETE FROM %v WHERE created_at < ?"), now-int64(s.config.MaxAgeSeconds))
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.query("INSERT INTO %v (state_key, state_value, created_at) VALUES (?, ?, ?)"), key, value, now)
+	return err
+}
+
+func (s *sqlStateStore) Get(key string) (string, error) {
+	var value string
+	var created int64
+	row := s.db.QueryRow(s.query("SELECT state_value, created_at FROM %v WHERE state_key = ?"), key)
+	if err := row.Scan(&value, &created); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("Could not find state for %v.", key)
+		}
+		return "", err
+	}
+	if time.Now().Unix()-created > int64(s.config.MaxAgeSeconds) {
+		return "", fmt.Errorf("The state for %v has expired.", key)
+	}
+	return value, nil
+}
+
+func (s *sqlStateStore) Delete(key string) error {
+	_, err := s.db.Exec(s.query("DELETE FROM %v WHERE state_key = ?"), key)
+	return err
+}
+
+// Add relies on the primary key to refuse a second row with the same key.
+func (s *sqlStateStore) Add(key, value string) (bool, error) {
+	if err := s.Put(key, value); err != nil {
+		if _, getErr := s.Get(key); getErr == nil {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Take only returns the value to the caller whose delete removed the row.
+func (s *sqlStateStore) Take(key string) (string, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return "", err
+	}
+	result, err := s.db.Exec(s.query("DELETE FROM %v WHERE state_key = ?"), key)
+	if err != nil {
+		return "", err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows != 1 {
+		return "", fmt.Errorf("Could not find state for %v.", key)
+	}
+	return value, nil
+}
+
+// query fills in the table name and rewrites the placeholders for the driver.
+func (s *sqlStateStore) query(format string) string {
+	query := fmt.Sprintf(format, s.config.Table)
+	if s.config.Placeholder != "$" {
+		return query
+	}
+	parts := strings.Split(query, "?")
+	query = parts[0]
+	for i, part := range parts[1:] {
+		query = fmt.Sprintf("%v$%d%v", query, i+1, part)
+	}
+	return query
+}
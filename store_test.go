@@ -0,0 +1,173 @@
+package goauth
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func testStateStore(t *testing.T, store StateStore) {
+	if err := store.Put("key", "value"); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if value, err := store.Get("key"); err != nil || value != "value" {
+		t.Logf("Expecting value but found %v (%v).", value, err)
+		t.Fail()
+	}
+	if err := store.Delete("key"); err != nil {
+		t.Log(err.Error())
+		t.Fail()
+	}
+	if _, err := store.Get("key"); err == nil {
+		t.Log("Expecting the deleted key to be missing.")
+		t.Fail()
+	}
+	if err := store.Delete("missing"); err != nil {
+		t.Logf("Deleting a missing key should not fail: %v.", err)
+		t.Fail()
+	}
+
+	// values can only be taken once
+	store.Put("key", "value")
+	if value, err := takeState(store, "key"); err != nil || value != "value" {
+		t.Logf("Expecting value but found %v (%v).", value, err)
+		t.Fail()
+	}
+	if _, err := takeState(store, "key"); err == nil {
+		t.Log("Expecting the taken key to be missing.")
+		t.Fail()
+	}
+
+	// values are only added once
+	if added, err := addState(store, "key", "value"); !added || err != nil {
+		t.Logf("Expecting the value to be added (%v).", err)
+		t.Fail()
+	}
+	if added, err := addState(store, "key", "other"); added || err != nil {
+		t.Logf("Expecting the existing value to be kept (%v).", err)
+		t.Fail()
+	}
+	store.Delete("key")
+}
+
+// slowStateStore widens the gap between looking up a value and changing it.
+type slowStateStore struct {
+	*tokenCache
+}
+
+func (s slowStateStore) Get(key string) (string, error) {
+	value, err := s.tokenCache.Get(key)
+	time.Sleep(10 * time.Millisecond)
+	return value, err
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	store := NewMemoryStateStore(10, 300)
+	testStateStore(t, store)
+	if store.(*tokenCache).size() != 0 {
+		t.Logf("Expecting cache size to be 0 but was %v.", store.(*tokenCache).size())
+		t.Fail()
+	}
+}
+
+func TestMemoryStateStoreExpiry(t *testing.T) {
+	cache := newTokenCache(10, 300)
+	cache.Put("expired", "value")
+	cache.items["expired"].timeIn = time.Now().Add(-time.Hour)
+	if _, err := cache.Get("expired"); err == nil {
+		t.Log("Expecting the expired value to be missing.")
+		t.Fail()
+	}
+
+	// the expired values make room first, then the least recently used
+	cache.Put("expired", "value")
+	cache.items["expired"].timeIn = time.Now().Add(-time.Hour)
+	cache.Put("login", "value")
+	for i := 0; i < 9; i++ {
+		if err := cache.Put(fmt.Sprint(i), "value"); err != nil {
+			t.Log(err.Error())
+			t.Fail()
+		}
+	}
+	if _, err := cache.Get("login"); err != nil || cache.size() != 10 {
+		t.Logf("Expecting the expired value to be removed before the live ones (%v).", err)
+		t.Fail()
+	}
+	cache.Put("another", "value")
+	if _, err := cache.Get("0"); err == nil || cache.size() != 10 {
+		t.Log("Expecting the least recently used value to be removed.")
+		t.Fail()
+	}
+
+	// a cache without a capacity only removes expired values
+	unbounded := newTokenCache(0, 300)
+	for i := 0; i < 100; i++ {
+		unbounded.Put(fmt.Sprint(i), "value")
+	}
+	if unbounded.size() != 100 {
+		t.Logf("Expecting 100 values but found %v.", unbounded.size())
+		t.Fail()
+	}
+}
+
+func TestFileStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goauth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileStateStore(dir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStateStore(t, store)
+
+	expiring, _ := NewFileStateStore(dir, time.Millisecond)
+	expiring.Put("key", "value")
+	time.Sleep(10 * time.Millisecond)
+	if _, err := expiring.Get("key"); err == nil {
+		t.Log("Expecting the key to have expired.")
+		t.Fail()
+	}
+}
+
+func TestSQLStateStoreQuery(t *testing.T) {
+	store := NewSQLStateStore(nil, SQLStateStoreConfig{Placeholder: "$"}).(*sqlStateStore)
+	query := store.query("INSERT INTO %v (state_key, state_value, created_at) VALUES (?, ?, ?)")
+	if query != "INSERT INTO goauth_state (state_key, state_value, created_at) VALUES ($1, $2, $3)" {
+		t.Logf("Invalid query %v.", query)
+		t.Fail()
+	}
+}
+
+func TestProviderStateStore(t *testing.T) {
+	store := NewMemoryStateStore(10, 300)
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.PKCEMethod = PKCEMethodS256
+	config.StateStore = store
+	provider := NewOAuth2ServiceProvider(config)
+
+	if _, err := provider.GetRedirectURL(); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	// the state flag and the code verifier
+	if store.(*tokenCache).size() != 2 {
+		t.Logf("Expecting the state in the configured store but found %v items.", store.(*tokenCache).size())
+		t.Fail()
+	}
+}
+
+func TestDefaultStateStore(t *testing.T) {
+	provider := NewOAuth2ServiceProvider(providerMap["google"].(OAuth2ServiceProviderConfig))
+	for i := 0; i < 2000; i++ {
+		if _, err := provider.GetRedirectURL(); err != nil {
+			t.Logf("Expecting abandoned logins not to prevent new ones: %v.", err)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package goauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// OAuth 1.0 token type.
+const OAuth1TokenType = "OAuth1"
+
+// standard token response fields, everything else is kept as an extra.
+var tokenResponseFields = []string{"access_token", "token_type", "refresh_token", "expires_in", "scope", oidcIDToken, oauthToken, oauthSecretToken}
+
+// Token is the full set of credentials issued by the provider at the end of
+// the authentication process. It can be serialized as JSON, stored, and later
+// used to make further requests on behalf of the user.
+type Token struct {
+	// AccessToken is the OAuth 1.0 or 2.0 access token.
+	AccessToken string `json:"access_token"`
+
+	// TokenType is the type of token, usually "Bearer" for OAuth 2.0 and
+	// "OAuth1" for OAuth 1.0.
+	TokenType string `json:"token_type,omitempty"`
+
+	// TokenSecret is the OAuth 1.0 token secret.
+	TokenSecret string `json:"token_secret,omitempty"`
+
+	// RefreshToken is the OAuth 2.0 refresh token, if one was issued.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// Expiry is the time the access token expires, zero if it does not expire.
+	Expiry time.Time `json:"expiry,omitempty"`
+
+	// Scopes are the scopes granted by the provider.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// IDToken is the OpenID Connect id token, if one was issued.
+	IDToken string `json:"id_token,omitempty"`
+
+	// Extra contains any other values the provider sent with the token.
+	Extra map[string]interface{} `json:"extra,omitempty"`
+}
+
+// Expired reports whether the access token has expired.
+func (t *Token) Expired() bool {
+	return !t.Expiry.IsZero() && t.Expiry.Before(time.Now())
+}
+
+// OAuth2Token converts this token into an oauth2.Token.
+func (t *Token) OAuth2Token() *oauth2.Token {
+	tok := &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
+	}
+	if len(t.Extra) > 0 || len(t.IDToken) > 0 {
+		extra := make(map[string]interface{}, len(t.Extra)+1)
+		for key, value := range t.Extra {
+			extra[key] = value
+		}
+		if len(t.IDToken) > 0 {
+			extra[oidcIDToken] = t.IDToken
+		}
+		tok = tok.WithExtra(extra)
+	}
+	return tok
+}
+
+// newOAuth2Token creates a token from the oauth2 token and the raw token response.
+// If the provider did not report the granted scopes, the requested scopes are used.
+func newOAuth2Token(tok *oauth2.Token, raw map[string]interface{}, requestedScopes []string) *Token {
+	t := &Token{
+		AccessToken:  tok.AccessToken,
+		TokenType:    tok.TokenType,
+		RefreshToken: tok.RefreshToken,
+		Expiry:       tok.Expiry,
+		Scopes:       requestedScopes,
+	}
+	t.IDToken, _ = tok.Extra(oidcIDToken).(string)
+	if scope, _ := tok.Extra("scope").(string); len(scope) > 0 {
+		t.Scopes = strings.Fields(scope)
+	}
+	t.Extra = tokenExtras(raw)
+	return t
+}
+
+// newOAuth1Token creates a token from an OAuth 1.0 access token response.
+func newOAuth1Token(values url.Values) *Token {
+	raw := make(map[string]interface{}, len(values))
+	for key := range values {
+		raw[key] = values.Get(key)
+	}
+	return &Token{
+		AccessToken: values.Get(oauthToken),
+		TokenType:   OAuth1TokenType,
+		TokenSecret: values.Get(oauthSecretToken),
+		Extra:       tokenExtras(raw),
+	}
+}
+
+func tokenExtras(raw map[string]interface{}) map[string]interface{} {
+	var extra map[string]interface{}
+	for key, value := range raw {
+		if !containsString(tokenResponseFields, key) {
+			if extra == nil {
+				extra = make(map[string]interface{})
+			}
+			extra[key] = value
+		}
+	}
+	return extra
+}
+
+// tokenResponseRecorder keeps a copy of the token endpoint's response so
+// values the oauth2 package does not expose can be added to the Token.
+type tokenResponseRecorder struct {
+	base http.RoundTripper
+	body []byte
+	mime string
+}
+
+// withTokenResponseRecorder returns a context which directs the oauth2 package to
+// use a client that records the token response.
+func withTokenResponseRecorder(ctx context.Context) (context.Context, *tokenResponseRecorder) {
+	client := http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+	recorder := &tokenResponseRecorder{base: client.Transport}
+	if recorder.base == nil {
+		recorder.base = http.DefaultTransport
+	}
+	recordingClient := *client
+	recordingClient.Transport = recorder
+	return context.WithValue(ctx, oauth2.HTTPClient, &recordingClient), recorder
+}
+
+func (r *tokenResponseRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	r.body = body
+	r.mime, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// values decodes the recorded response, which may be either JSON or form encoded.
+func (r *tokenResponseRecorder) values() map[string]interface{} {
+	raw := make(map[string]interface{})
+	switch r.mime {
+	case "application/x-www-form-urlencoded", "text/plain":
+		values, err := url.ParseQuery(string(r.body))
+		if err == nil {
+			for key := range values {
+				raw[key] = values.Get(key)
+			}
+		}
+	default:
+		json.Unmarshal(r.body, &raw)
+	}
+	return raw
+}
@@ -0,0 +1,95 @@
+package goauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTokenSerialization(t *testing.T) {
+	tok := &Token{
+		AccessToken:  "abc",
+		TokenType:    "Bearer",
+		RefreshToken: "def",
+		Expiry:       time.Now().Add(time.Hour).Round(time.Second),
+		Scopes:       []string{"email", "profile"},
+		IDToken:      "a.b.c",
+		Extra:        map[string]interface{}{"user_id": "123"},
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	rehydrated := &Token{}
+	if err = json.Unmarshal(data, rehydrated); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if rehydrated.RefreshToken != "def" || !rehydrated.Expiry.Equal(tok.Expiry) || len(rehydrated.Scopes) != 2 || rehydrated.Extra["user_id"] != "123" {
+		t.Logf("Token %v was not rehydrated correctly from %v.", rehydrated, string(data))
+		t.Fail()
+	}
+
+	oauth2Token := rehydrated.OAuth2Token()
+	if oauth2Token.RefreshToken != "def" || oauth2Token.Extra(oidcIDToken) != "a.b.c" || oauth2Token.Extra("user_id") != "123" {
+		t.Logf("Invalid oauth2 token %v.", oauth2Token)
+		t.Fail()
+	}
+	if rehydrated.Expired() {
+		t.Log("Token should not be expired.")
+		t.Fail()
+	}
+}
+
+func TestNewOAuth1Token(t *testing.T) {
+	values, _ := url.ParseQuery("oauth_token=abc&oauth_token_secret=def&user_id=123&screen_name=jdoe")
+	tok := newOAuth1Token(values)
+	if tok.AccessToken != "abc" || tok.TokenSecret != "def" || tok.TokenType != OAuth1TokenType {
+		t.Logf("Invalid token %v.", tok)
+		t.Fail()
+	}
+	if len(tok.Extra) != 2 || tok.Extra["screen_name"] != "jdoe" {
+		t.Logf("Invalid token extras %v.", tok.Extra)
+		t.Fail()
+	}
+}
+
+func TestProcessResponseToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			w.Write([]byte(`{"access_token":"abc","token_type":"Bearer","refresh_token":"def","expires_in":3600,"scope":"email profile","user_id":"123"}`))
+		case "/userinfo":
+			w.Write([]byte(`{"id":"123","name":"Jane Doe"}`))
+		}
+	}))
+	defer server.Close()
+
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	config.UserInfoURL = server.URL + "/userinfo"
+	provider := NewOAuth2ServiceProvider(config)
+
+	redirectURL, _ := provider.GetRedirectURL()
+	redirect, _ := url.Parse(redirectURL)
+	request := httptest.NewRequest("GET", "/oauth/callback/google?code=xyz&state="+url.QueryEscape(redirect.Query().Get(oauth2StateFlag)), nil)
+	user, err := provider.ProcessResponse(request)
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	tok := user.Token
+	if tok == nil || tok.AccessToken != "abc" || tok.RefreshToken != "def" || tok.Expiry.IsZero() {
+		t.Logf("Invalid token %v.", tok)
+		t.FailNow()
+	}
+	if len(tok.Scopes) != 2 || tok.Scopes[0] != "email" || tok.Extra["user_id"] != "123" {
+		t.Logf("Invalid token scopes %v or extras %v.", tok.Scopes, tok.Extra)
+		t.Fail()
+	}
+}
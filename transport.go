@@ -0,0 +1,142 @@
+package goauth
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+)
+
+// oauth1Transport is an http.RoundTripper which signs every request with the
+// provider's consumer credentials and the user's access token.
+type oauth1Transport struct {
+	provider *OAuth1ServiceProvider
+	token    *Token
+	base     http.RoundTripper
+}
+
+func (t *oauth1Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the original request
+	signed := req.Clone(req.Context())
+	signed.GetBody = nil
+	if err := bufferBody(signed); err != nil {
+		return nil, err
+	}
+	if err := t.provider.SignRequest(signed, t.token); err != nil {
+		return nil, err
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// SignRequest adds an OAuth Authorization header to the request, signed with the
+// consumer credentials and the token. The token may be nil to make two legged
+// requests, signed with the consumer credentials only. The signature covers the
+// oauth parameters, the query string and any form encoded body; other bodies are
+// covered by an oauth_body_hash parameter. The body is left readable.
+func (provider *OAuth1ServiceProvider) SignRequest(req *http.Request, tok *Token) error {
+	var tokenValue, tokenSecret string
+	if tok != nil {
+		tokenValue = tok.AccessToken
+		tokenSecret = tok.TokenSecret
+	}
+	params, err := provider.generateParams(tokenValue)
+	if err != nil {
+		return err
+	}
+
+	if err = bufferBody(req); err != nil {
+		return err
+	}
+	body, err := formParameters(req)
+	if err != nil {
+		return err
+	}
+	if body == nil && req.GetBody != nil && req.ContentLength != 0 {
+		if params[oauthBodyHash], err = provider.bodyHash(req); err != nil {
+			return err
+		}
+	}
+	baseString := createBaseString(req.Method, req.URL, collectParameters(req.URL, body, params))
+
+	if params[oauthSignature], err = provider.createMethodSignature(baseString, tokenSecret); err != nil {
+		return err
+	}
+	req.Header.Set(oauthAuthorization, provider.createHeader(params))
+	return nil
+}
+
+// bodyHash hashes a body which is not form encoded, using the hash algorithm of
+// the signature method, as described by the OAuth Request Body Hash extension.
+func (provider *OAuth1ServiceProvider) bodyHash(req *http.Request) (string, error) {
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return bodyHash(provider.signer.Name(), body)
+}
+
+func bodyHash(signatureMethod string, body io.Reader) (string, error) {
+	h := sha1.New()
+	if signatureMethod == SignatureMethodHMACSHA256 {
+		h = sha256.New()
+	}
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// bufferBody reads the body into memory so that it can be read more than once,
+// unless it can already be re-read.
+func bufferBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// formParameters returns the parameters of a form encoded request body. The body
+// is left readable.
+func formParameters(req *http.Request) (url.Values, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("Cannot sign a form body which cannot be re-read.")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(data))
+}
@@ -0,0 +1,196 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOAuth1Client(t *testing.T) {
+	var header, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(oauthAuthorization)
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ProviderName: "test",
+		ClientID:     "CLIENT_ID",
+		ClientSecret: "CLIENT_SECRET",
+	})
+	client, err := provider.Client(context.Background(), &Token{AccessToken: "abc", TokenSecret: "def", TokenType: OAuth1TokenType})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+
+	_, err = client.PostForm(server.URL+"/statuses/update.json?include_entities=true", url.Values{"status": []string{"Hello Ladies + Gentlemen"}})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if !strings.HasPrefix(header, oauthPreamble+" ") || !strings.Contains(header, `oauth_token="abc"`) ||
+		!strings.Contains(header, `oauth_consumer_key="CLIENT_ID"`) || !strings.Contains(header, oauthSignature+"=") {
+		t.Logf("Invalid authorization header %v.", header)
+		t.Fail()
+	}
+	if body != "status=Hello+Ladies+%2B+Gentlemen" {
+		t.Logf("Invalid body %v.", body)
+		t.Fail()
+	}
+}
+
+func TestOAuth2ClientRefresh(t *testing.T) {
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			r.ParseForm()
+			if r.PostForm.Get("refresh_token") != "def" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"refreshed","token_type":"Bearer","expires_in":3600}`))
+		default:
+			authorization = r.Header.Get("Authorization")
+		}
+	}))
+	defer server.Close()
+
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	provider := NewOAuth2ServiceProvider(config)
+
+	client, err := provider.Client(context.Background(), &Token{
+		AccessToken:  "abc",
+		TokenType:    "Bearer",
+		RefreshToken: "def",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if _, err = client.Get(server.URL + "/api"); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	if authorization != "Bearer refreshed" {
+		t.Logf("Expecting the refreshed token but found %v.", authorization)
+		t.Fail()
+	}
+}
+
+func TestOAuth2ClientRotatedRefreshToken(t *testing.T) {
+	var refreshes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			r.ParseForm()
+			if r.PostForm.Get("refresh_token") != fmt.Sprintf("refresh%d", refreshes) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			refreshes++
+			w.Header().Set("Content-Type", "application/json")
+			// the provider rotates the refresh token and the access token expires at once
+			fmt.Fprintf(w, `{"access_token":"access%d","token_type":"Bearer","refresh_token":"refresh%d","expires_in":1}`, refreshes, refreshes)
+		}
+	}))
+	defer server.Close()
+
+	var stored *Token
+	config := providerMap["google"].(OAuth2ServiceProviderConfig)
+	config.TokenURL = server.URL + "/token"
+	config.OnTokenRefresh = func(token *Token) error {
+		stored = token
+		return nil
+	}
+	provider := NewOAuth2ServiceProvider(config)
+
+	stored = &Token{AccessToken: "access0", TokenType: "Bearer", RefreshToken: "refresh0", Expiry: time.Now().Add(-time.Hour)}
+	for i := 1; i <= 2; i++ {
+		// each client starts from the stored token, as it would after a restart
+		client, err := provider.Client(context.Background(), stored)
+		if err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if _, err = client.Get(server.URL + "/api"); err != nil {
+			t.Log(err.Error())
+			t.FailNow()
+		}
+		if stored.AccessToken != fmt.Sprintf("access%d", i) || stored.RefreshToken != fmt.Sprintf("refresh%d", i) {
+			t.Logf("Expecting the refreshed token to be stored but found %v.", stored)
+			t.FailNow()
+		}
+	}
+
+	config.OnTokenRefresh = func(token *Token) error {
+		return errors.New("The database is down.")
+	}
+	client, _ := NewOAuth2ServiceProvider(config).Client(context.Background(), stored)
+	if _, err := client.Get(server.URL + "/api"); err == nil {
+		t.Log("Expecting an error when the refreshed token cannot be stored.")
+		t.Fail()
+	}
+}
+
+func TestSignRequestBodyHash(t *testing.T) {
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{
+		ClientID:     "CLIENT_ID",
+		ClientSecret: "CLIENT_SECRET",
+	}).(*OAuth1ServiceProvider)
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials})
+
+	body := `{"status":"Hello"}`
+	req, _ := http.NewRequest("PUT", "http://api.example.com/statuses/1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// a two legged request, without a token
+	if err := provider.SignRequest(req, nil); err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	header := req.Header.Get(oauthAuthorization)
+	// base64 of the SHA-1 hash of the body
+	if !strings.Contains(header, `oauth_body_hash="RUkNWS1rw11wUVhVhbr9ojTgC3E%3D"`) || strings.Contains(header, oauthToken+"=") {
+		t.Logf("Invalid authorization header %v.", header)
+		t.Fail()
+	}
+	if data, _ := ioutil.ReadAll(req.Body); string(data) != body {
+		t.Logf("Expecting the body to be readable but found %v.", string(data))
+		t.Fail()
+	}
+
+	server := httptest.NewRequest("PUT", "http://api.example.com/statuses/1", strings.NewReader(body))
+	server.Header = req.Header
+	if _, err := verifier.Verify(server); err != nil {
+		t.Logf("Expecting the body hash to be verified: %v.", err)
+		t.Fail()
+	}
+	tampered := httptest.NewRequest("PUT", "http://api.example.com/statuses/1", strings.NewReader(`{"status":"Goodbye"}`))
+	tampered.Header = req.Header
+	if _, err := verifier.Verify(tampered); verificationProblem(err) != "signature_invalid" {
+		t.Logf("Expecting a tampered body to be rejected but found %v.", err)
+		t.Fail()
+	}
+
+	// form bodies are signed instead of hashed
+	form, _ := http.NewRequest("POST", "http://api.example.com/statuses", strings.NewReader("status=Hello"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	provider.SignRequest(form, nil)
+	if strings.Contains(form.Header.Get(oauthAuthorization), oauthBodyHash) {
+		t.Log("Expecting form bodies not to be hashed.")
+		t.Fail()
+	}
+}
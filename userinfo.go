@@ -0,0 +1,123 @@
+package goauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// UserInfoRequest is a further request for user information, sent with the same
+// credentials after the UserInfoURL, for providers which only send part of the
+// user information from the UserInfoURL (eg: GitHub sends the user's email
+// addresses from /user/emails).
+type UserInfoRequest struct {
+
+	// URL is the URL to fetch the user information from.
+	URL string
+
+	// Claim is the name the response is added to the user information with, so
+	// that the claim mapping can refer to it (eg: emails). When empty the response
+	// must be a JSON object, which is merged into the user information without
+	// replacing the values already there.
+	Claim string
+}
+
+// EmailAddress is one of the user's email addresses.
+type EmailAddress struct {
+	Address  string
+	Verified bool
+	Primary  bool
+}
+
+// the names of the email address attributes used by the common providers.
+var (
+	emailAddressNames  = []string{"email", "value", "address"}
+	emailVerifiedNames = []string{"verified", "email_verified", "is_confirmed", "confirmed"}
+	emailPrimaryNames  = []string{"primary", "is_primary"}
+)
+
+// decodeUserInfo decodes a JSON or form encoded user information response.
+func decodeUserInfo(data []byte, mediaType string) (interface{}, error) {
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{}, len(values))
+		for key, vals := range values {
+			m[key] = vals[0]
+		}
+		return m, nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// mergeUserInfo adds the response to a UserInfoRequest to the user information.
+func mergeUserInfo(data map[string]interface{}, request UserInfoRequest, value interface{}) error {
+	if len(request.Claim) > 0 {
+		data[request.Claim] = value
+		return nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("The user information from %v is not a JSON object.", request.URL)
+	}
+	for key, val := range m {
+		if data[key] == nil {
+			data[key] = val
+		}
+	}
+	return nil
+}
+
+// emailAddresses reads the email addresses found at the paths. Each address is
+// either a string or an object with the address and whether it is verified and
+// primary.
+func emailAddresses(data map[string]interface{}, paths []string) []EmailAddress {
+	var emails []EmailAddress
+	for _, path := range paths {
+		value := lookupClaim(data, path)
+		if m, ok := value.(map[string]interface{}); ok {
+			// eg: Bitbucket's {"values": [...]}
+			value = m["values"]
+		}
+		vals, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		for _, val := range vals {
+			switch v := val.(type) {
+			case string:
+				emails = append(emails, EmailAddress{Address: v})
+			case map[string]interface{}:
+				email := EmailAddress{
+					Address:  lookupClaims(v, emailAddressNames),
+					Verified: lookupBool(v, emailVerifiedNames),
+					Primary:  lookupBool(v, emailPrimaryNames),
+				}
+				if len(email.Address) > 0 {
+					emails = append(emails, email)
+				}
+			}
+		}
+	}
+	return emails
+}
+
+// lookupBool returns true if the first of the paths with a value is true or the
+// string "true".
+func lookupBool(data map[string]interface{}, paths []string) bool {
+	for _, path := range paths {
+		switch value := lookupClaim(data, path).(type) {
+		case bool:
+			return value
+		case string:
+			return value == "true"
+		}
+	}
+	return false
+}
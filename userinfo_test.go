@@ -0,0 +1,138 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestOAuth2UserInfoRequests(t *testing.T) {
+	var emails string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/token":
+			w.Write([]byte(`{"access_token":"abc","token_type":"Bearer"}`))
+		case "/user/emails":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(emails))
+		case "/user":
+			w.Write([]byte(`{"id":583231,"login":"octocat","email":null}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	preset, _ := LookupPreset("github")
+	config := preset.OAuth2Config("CLIENT_ID", "CLIENT_SECRET", "http://myserver.com/oauth/callback/github")
+	config.AllowUnboundState = true
+	config.TokenURL = server.URL + "/token"
+	config.UserInfoURL = server.URL + "/user"
+	config.UserInfoRequests[0].URL = server.URL + "/user/emails"
+	provider := NewOAuth2ServiceProvider(config).(*OAuth2ServiceProvider)
+	login := func() (UserData, error) {
+		stateFlag, _ := provider.generateStateFlag(context.Background())
+		return provider.ProcessResponse(httptest.NewRequest("GET", "/oauth/callback/github?code=xyz&state="+url.QueryEscape(stateFlag), nil))
+	}
+
+	emails = `[
+  {"email":"octocat@old.example.com","primary":false,"verified":false},
+  {"email":"octocat@example.com","primary":true,"verified":true},
+  {"email":"octocat@users.noreply.github.com","primary":false,"verified":true}
+]`
+	user, err := login()
+	if err != nil || user.Email != "octocat@example.com" || !user.EmailVerified || len(user.Emails) != 3 || !user.Emails[1].Primary {
+		t.Logf("Invalid user %v %v (%v).", user, user.Emails, err)
+		t.Fail()
+	}
+	if string(user.RawJSON) != `{"id":583231,"login":"octocat","email":null}` {
+		t.Logf("Expecting the raw JSON of the user information but found %s.", user.RawJSON)
+		t.Fail()
+	}
+
+	emails = `{"message":"Not Found"}`
+	if user, err = login(); err != nil || len(user.Email) > 0 || user.EmailVerified {
+		t.Logf("Expecting no email address but found %v (%v).", user, err)
+		t.Fail()
+	}
+
+	provider.userInfoRequests = []UserInfoRequest{{URL: server.URL + "/user/emails"}}
+	emails = `[]`
+	if _, err = login(); !errors.Is(err, ErrUserInfo) {
+		t.Logf("Expecting a list which cannot be merged to be reported but found %v.", err)
+		t.Fail()
+	}
+	provider.userInfoRequests = []UserInfoRequest{{URL: server.URL + "/missing"}}
+	if _, err = login(); !errors.Is(err, ErrUserInfo) {
+		t.Logf("Expecting a failed request to be reported but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestEmailClaims(t *testing.T) {
+	tests := []struct {
+		data     map[string]interface{}
+		email    string
+		verified bool
+		emails   int
+	}{
+		// the verified flag of an OpenID Connect provider
+		{map[string]interface{}{"email": "a@example.com", "email_verified": true}, "a@example.com", true, 1},
+		{map[string]interface{}{"email": "a@example.com", "email_verified": "false"}, "a@example.com", false, 1},
+		// the email address is verified by the list of addresses
+		{map[string]interface{}{"email": "a@example.com", "emails": []interface{}{
+			map[string]interface{}{"email": "a@example.com", "verified": true},
+		}}, "a@example.com", true, 1},
+		// the verified address is preferred to the unverified primary address
+		{map[string]interface{}{"emails": []interface{}{
+			map[string]interface{}{"email": "a@example.com", "primary": true},
+			map[string]interface{}{"email": "b@example.com", "verified": true},
+		}}, "b@example.com", true, 2},
+		// a list of strings and unexpected values
+		{map[string]interface{}{"emails": []interface{}{"a@example.com", nil, 42, map[string]interface{}{"email": nil}}}, "a@example.com", false, 1},
+		{map[string]interface{}{"emails": "a@example.com"}, "", false, 0},
+	}
+	for _, test := range tests {
+		user := defaultClaimMapping.userData(test.data, nil)
+		if user.Email != test.email || user.EmailVerified != test.verified || len(user.Emails) != test.emails {
+			t.Logf("Expecting %v (%v, %v addresses) from %v but found %v (%v, %v).", test.email, test.verified,
+				test.emails, test.data, user.Email, user.EmailVerified, user.Emails)
+			t.Fail()
+		}
+	}
+}
+
+func TestConfigureUserInfoRequests(t *testing.T) {
+	yamlString := `Test:
+  OAuthVersion: 2.0
+  ClientID:     abc123
+  ClientSecret: xyz456
+  UserInfoRequests:
+    - URL:   https://api.example.com/user/emails
+      Claim: emails
+    - URL:   https://api.example.com/user/teams`
+	providers, err := ConfigureProvidersFromYAML(strings.NewReader(yamlString), "http://myhost/oauth/callback/%v")
+	if err != nil {
+		t.Log(err.Error())
+		t.FailNow()
+	}
+	requests := providers["test"].(*OAuth2ServiceProvider).userInfoRequests
+	if len(requests) != 2 || requests[0].Claim != "emails" || requests[1].URL != "https://api.example.com/user/teams" {
+		t.Logf("Invalid user information requests %v.", requests)
+		t.Fail()
+	}
+
+	jsonString := `{"Test":{"OAuthVersion":2.0,"ClientID":"abc123","ClientSecret":"xyz456","UserInfoRequests":["https://api.example.com/user/emails"]}}`
+	if _, err = ConfigureProvidersFromJSON(strings.NewReader(jsonString), "http://myhost/oauth/callback/%v"); err == nil {
+		t.Log("Expecting an error for an invalid user information request.")
+		t.Fail()
+	}
+}
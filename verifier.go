@@ -0,0 +1,393 @@
+package goauth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	oauthRealm                    = "realm"
+	oauth1DefaultTimestampWindow  = 300
+	oauth1VerificationMaxBodySize = 1 << 20
+)
+
+type oauth1CredentialsContextKey struct{}
+
+// CredentialStore looks up the secrets of the consumers and tokens allowed to
+// call an API protected by an OAuth1Verifier.
+type CredentialStore interface {
+	// ConsumerSecret returns the secret of the consumer key, or an error if the
+	// consumer is unknown.
+	ConsumerSecret(consumerKey string) (string, error)
+
+	// TokenSecret returns the secret of a token issued to the consumer, or an error
+	// if the token is unknown, revoked or was issued to another consumer.
+	TokenSecret(consumerKey, token string) (string, error)
+}
+
+// PublicKeyStore is implemented by CredentialStores which support consumers
+// signing their requests with the RSA-SHA1 signature method.
+type PublicKeyStore interface {
+	// ConsumerPublicKey returns the RSA public key of the consumer key.
+	ConsumerPublicKey(consumerKey string) (*rsa.PublicKey, error)
+}
+
+// OAuth1VerifierConfig is a simple struct which can be used to initialize an
+// OAuth1Verifier.
+type OAuth1VerifierConfig struct {
+
+	// Credentials looks up the consumer and token secrets.
+	Credentials CredentialStore
+
+	// SignatureMethods are the signature methods accepted. Defaults to HMAC-SHA1
+	// and HMAC-SHA256. PLAINTEXT is only accepted over TLS, or when the BaseURL
+	// uses https.
+	SignatureMethods []string
+
+	// TimestampWindowSeconds is how far the timestamp of a request may be from the
+	// server's clock. Defaults to 300 seconds.
+	TimestampWindowSeconds int
+
+	// NonceStore remembers the nonces used within the timestamp window to reject
+	// replayed requests. Defaults to an in memory store, which must be replaced by a
+	// shared store when running more than one instance. Stores which do not
+	// implement AtomicStateStore cannot reject replays sent at the same time.
+	NonceStore StateStore
+
+	// BaseURL is the scheme and host (eg: https://api.example.com) clients use to
+	// reach the server, for servers behind a proxy which changes them. Defaults to
+	// the scheme and host of the request.
+	BaseURL string
+
+	// Realm is sent in the WWW-Authenticate header of rejected requests.
+	Realm string
+
+	// Clock is compared with the timestamp of each request. Defaults to the system
+	// clock.
+	Clock Clock
+}
+
+// OAuth1Credentials identifies the consumer and token which signed a verified
+// request.
+type OAuth1Credentials struct {
+	ConsumerKey string
+	Token       string
+}
+
+// OAuth1Verifier verifies OAuth 1.0a signed requests made to the server, for
+// servers exposing an API to OAuth 1.0 clients.
+type OAuth1Verifier struct {
+	config  OAuth1VerifierConfig
+	signers map[string]Signer
+}
+
+// oauth1VerificationError explains why a request was rejected, using the problem
+// names of the OAuth problem reporting extension.
+type oauth1VerificationError struct {
+	status      int
+	problem     string
+	description string
+}
+
+func (e *oauth1VerificationError) Error() string {
+	return e.description
+}
+
+func newVerificationError(status int, problem, description string, args ...interface{}) error {
+	return &oauth1VerificationError{status: status, problem: problem, description: fmt.Sprintf(description, args...)}
+}
+
+// NewOAuth1Verifier creates an OAuth1Verifier.
+func NewOAuth1Verifier(config OAuth1VerifierConfig) *OAuth1Verifier {
+	if len(config.SignatureMethods) == 0 {
+		config.SignatureMethods = []string{SignatureMethodHMACSHA1, SignatureMethodHMACSHA256}
+	}
+	if config.TimestampWindowSeconds < 1 {
+		config.TimestampWindowSeconds = oauth1DefaultTimestampWindow
+	}
+	if config.NonceStore == nil {
+		// a nonce is kept until its timestamp is outside the window, however many
+		// requests arrive in the meantime
+		config.NonceStore = newTokenCache(0, 2*config.TimestampWindowSeconds)
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+
+	return &OAuth1Verifier{
+		config: config,
+		signers: map[string]Signer{
+			SignatureMethodHMACSHA1:   NewHMACSHA1Signer(),
+			SignatureMethodHMACSHA256: NewHMACSHA256Signer(),
+			SignatureMethodPlaintext:  NewPlaintextSigner(),
+		},
+	}
+}
+
+// Handler returns an http.Handler which only passes requests with a valid
+// signature to the next handler. The credentials are available to the handler
+// through OAuth1CredentialsFromContext. Rejected requests receive a 400 or 401
+// response, and requests which could not be checked (eg: because the NonceStore
+// failed) a 500 response.
+func (v *OAuth1Verifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials, err := v.Verify(r)
+		if err != nil {
+			verr, ok := err.(*oauth1VerificationError)
+			if !ok {
+				// the request could not be checked, which says nothing about its signature
+				respondWithError(w, r, http.StatusInternalServerError, "server_error", http.StatusText(http.StatusInternalServerError))
+				return
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("%v realm=\"%v\", %v=\"%v\"", oauthPreamble, v.config.Realm, oauthProblem, verr.problem))
+			respondWithError(w, r, verr.status, verr.problem, verr.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), oauth1CredentialsContextKey{}, credentials)))
+	})
+}
+
+// OAuth1CredentialsFromContext returns the credentials of the request verified by
+// the OAuth1Verifier's Handler.
+func OAuth1CredentialsFromContext(ctx context.Context) (OAuth1Credentials, bool) {
+	credentials, found := ctx.Value(oauth1CredentialsContextKey{}).(OAuth1Credentials)
+	return credentials, found
+}
+
+// Verify checks the signature of the request, given either in the Authorization
+// header or in the query and form parameters, returning the credentials which
+// signed it. The timestamp must be within the window and the nonce must not have
+// been used before. A form encoded body is left readable.
+func (v *OAuth1Verifier) Verify(r *http.Request) (OAuth1Credentials, error) {
+	var credentials OAuth1Credentials
+
+	requestURL, err := v.requestURL(r)
+	if err != nil {
+		return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "Invalid request URL: %v.", err)
+	}
+	body, err := bufferedFormParameters(r)
+	if err != nil {
+		return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "Invalid request body: %v.", err)
+	}
+
+	// the oauth parameters are in the header, or else amongst the request parameters
+	var params map[string]string
+	var headerParams map[string]string
+	if authorization := r.Header.Get(oauthAuthorization); strings.HasPrefix(strings.ToLower(authorization), strings.ToLower(oauthPreamble)+" ") {
+		if headerParams, err = parseAuthorizationHeader(authorization[len(oauthPreamble)+1:]); err != nil {
+			return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "Invalid authorization header: %v.", err)
+		}
+		params = headerParams
+	} else {
+		params = make(map[string]string)
+		query, _ := url.ParseQuery(requestURL.RawQuery)
+		for _, values := range []url.Values{query, body} {
+			for key, vals := range values {
+				if strings.HasPrefix(key, "oauth_") && len(vals) > 0 {
+					params[key] = vals[0]
+				}
+			}
+		}
+	}
+
+	signatureMethod := params[oauthSignatureMethod]
+	required := []string{oauthConsumerKey, oauthSignatureMethod, oauthSignature}
+	if signatureMethod != SignatureMethodPlaintext {
+		required = append(required, oauthTimestamp, oauthNonce)
+	}
+	for _, key := range required {
+		if len(params[key]) == 0 {
+			return credentials, newVerificationError(http.StatusBadRequest, "parameter_absent", "Missing the %v parameter.", key)
+		}
+	}
+	if version, found := params[oauthVersion]; found && version != OAuthVersion1 {
+		return credentials, newVerificationError(http.StatusBadRequest, "version_rejected", "Unsupported OAuth version %v.", version)
+	}
+	if !containsString(v.config.SignatureMethods, signatureMethod) {
+		return credentials, newVerificationError(http.StatusBadRequest, "signature_method_rejected", "Unsupported signature method %v.", signatureMethod)
+	}
+	// PLAINTEXT sends the secrets and has no timestamp or nonce, so only TLS protects it
+	if signatureMethod == SignatureMethodPlaintext && requestURL.Scheme != "https" {
+		return credentials, newVerificationError(http.StatusBadRequest, "signature_method_rejected", "The %v signature method requires TLS.", signatureMethod)
+	}
+	if len(params[oauthTimestamp]) > 0 {
+		timestamp, err := strconv.ParseInt(params[oauthTimestamp], 10, 64)
+		if err != nil {
+			return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "Invalid timestamp %v.", params[oauthTimestamp])
+		}
+		if skew := v.config.Clock.Now().Unix() - timestamp; skew > int64(v.config.TimestampWindowSeconds) || -skew > int64(v.config.TimestampWindowSeconds) {
+			return credentials, newVerificationError(http.StatusUnauthorized, "timestamp_refused", "The timestamp is too far from the server time.")
+		}
+	}
+
+	credentials.ConsumerKey = params[oauthConsumerKey]
+	credentials.Token = params[oauthToken]
+	consumerSecret, err := v.config.Credentials.ConsumerSecret(credentials.ConsumerKey)
+	if err != nil {
+		return credentials, newVerificationError(http.StatusUnauthorized, "consumer_key_unknown", "Unknown consumer key %v.", credentials.ConsumerKey)
+	}
+	var tokenSecret string
+	if len(credentials.Token) > 0 {
+		if tokenSecret, err = v.config.Credentials.TokenSecret(credentials.ConsumerKey, credentials.Token); err != nil {
+			return credentials, newVerificationError(http.StatusUnauthorized, "token_rejected", "Invalid or expired token.")
+		}
+	}
+
+	baseString := createBaseString(r.Method, requestURL, collectParameters(requestURL, body, headerParams))
+	if err = v.verifySignature(signatureMethod, baseString, params[oauthSignature], credentials.ConsumerKey, consumerSecret, tokenSecret); err != nil {
+		return credentials, err
+	}
+	if expected, found := params[oauthBodyHash]; found {
+		if body != nil {
+			return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "The %v parameter is not allowed with a form body.", oauthBodyHash)
+		}
+		data, err := bufferedBody(r)
+		if err != nil {
+			return credentials, newVerificationError(http.StatusBadRequest, "parameter_rejected", "Invalid request body: %v.", err)
+		}
+		if actual, _ := bodyHash(signatureMethod, bytes.NewReader(data)); !hmac.Equal([]byte(actual), []byte(expected)) {
+			return credentials, newVerificationError(http.StatusUnauthorized, "signature_invalid", "The body does not match the %v parameter.", oauthBodyHash)
+		}
+	}
+
+	// only remember the nonces of authentic requests
+	if len(params[oauthNonce]) > 0 {
+		nonceKey := stateKeyOAuth1Nonce + strings.Join([]string{credentials.ConsumerKey, credentials.Token, params[oauthTimestamp], params[oauthNonce]}, "&")
+		added, err := addState(v.config.NonceStore, nonceKey, params[oauthTimestamp])
+		if err != nil {
+			return credentials, fmt.Errorf("Could not store the nonce: %w", err)
+		}
+		if !added {
+			return credentials, newVerificationError(http.StatusUnauthorized, "nonce_used", "The nonce has already been used.")
+		}
+	}
+	return credentials, nil
+}
+
+func (v *OAuth1Verifier) verifySignature(signatureMethod, baseString, signature, consumerKey, consumerSecret, tokenSecret string) error {
+	invalid := newVerificationError(http.StatusUnauthorized, "signature_invalid", "Invalid signature.")
+	if signatureMethod == SignatureMethodRSASHA1 {
+		keys, ok := v.config.Credentials.(PublicKeyStore)
+		if !ok {
+			return newVerificationError(http.StatusBadRequest, "signature_method_rejected", "Unsupported signature method %v.", signatureMethod)
+		}
+		publicKey, err := keys.ConsumerPublicKey(consumerKey)
+		if err != nil {
+			return newVerificationError(http.StatusUnauthorized, "consumer_key_unknown", "Unknown consumer key %v.", consumerKey)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			return invalid
+		}
+		hashed := sha1.Sum([]byte(baseString))
+		if rsa.VerifyPKCS1v15(publicKey, crypto.SHA1, hashed[:], decoded) != nil {
+			return invalid
+		}
+		return nil
+	}
+
+	signer, found := v.signers[signatureMethod]
+	if !found {
+		return newVerificationError(http.StatusBadRequest, "signature_method_rejected", "Unsupported signature method %v.", signatureMethod)
+	}
+	expected, err := signer.Sign(baseString, consumerSecret, tokenSecret)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return invalid
+	}
+	return nil
+}
+
+// requestURL rebuilds the URL the client signed from the server side request.
+func (v *OAuth1Verifier) requestURL(r *http.Request) (*url.URL, error) {
+	if len(v.config.BaseURL) > 0 {
+		return url.Parse(v.config.BaseURL + r.URL.RequestURI())
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return url.Parse(scheme + "://" + r.Host + r.URL.RequestURI())
+}
+
+// bufferedFormParameters reads the parameters of a form encoded body, replacing
+// the body so that the next handler can read it again.
+func bufferedFormParameters(r *http.Request) (url.Values, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/x-www-form-urlencoded" {
+		return nil, nil
+	}
+	data, err := bufferedBody(r)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(data))
+}
+
+// bufferedBody reads the body, replacing it so that the next handler can read it
+// again.
+func bufferedBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, oauth1VerificationMaxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > oauth1VerificationMaxBodySize {
+		return nil, errors.New("the body is too large")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// parseAuthorizationHeader parses the parameters of an OAuth Authorization header
+// (RFC 5849 section 3.5.1), excluding the realm.
+func parseAuthorizationHeader(header string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, param := range strings.Split(header, ",") {
+		param = strings.TrimSpace(param)
+		if len(param) == 0 {
+			continue
+		}
+		separator := strings.Index(param, "=")
+		if separator < 0 {
+			return nil, fmt.Errorf("malformed parameter %v", param)
+		}
+		key, err := url.PathUnescape(param[:separator])
+		if err != nil {
+			return nil, err
+		}
+		value := strings.TrimSpace(param[separator+1:])
+		if len(value) < 2 || !strings.HasPrefix(value, "\"") || !strings.HasSuffix(value, "\"") {
+			return nil, fmt.Errorf("unquoted parameter %v", key)
+		}
+		if value, err = url.PathUnescape(value[1 : len(value)-1]); err != nil {
+			return nil, err
+		}
+		if key != oauthRealm {
+			params[key] = value
+		}
+	}
+	return params, nil
+}
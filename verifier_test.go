@@ -0,0 +1,224 @@
+package goauth
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type testCredentials map[string]string
+
+func (c testCredentials) ConsumerSecret(consumerKey string) (string, error) {
+	if secret, found := c[consumerKey]; found {
+		return secret, nil
+	}
+	return "", errors.New("Unknown consumer.")
+}
+
+func (c testCredentials) TokenSecret(consumerKey, token string) (string, error) {
+	if secret, found := c[consumerKey+":"+token]; found {
+		return secret, nil
+	}
+	return "", errors.New("Unknown token.")
+}
+
+var verifierCredentials = testCredentials{"CLIENT_ID": "CLIENT_SECRET", "CLIENT_ID:abc": "def"}
+
+// newSignedRequest creates a request signed by an OAuth 1.0 client.
+func newSignedRequest(t *testing.T, clientSecret string, tok *Token, method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if len(body) > 0 {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(strings.NewReader(body)), nil
+	}
+	provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{ClientID: "CLIENT_ID", ClientSecret: clientSecret}).(*OAuth1ServiceProvider)
+	if err := provider.SignRequest(req, tok); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func verificationProblem(err error) string {
+	if verr, ok := err.(*oauth1VerificationError); ok {
+		return verr.problem
+	}
+	return ""
+}
+
+func TestOAuth1Verifier(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials})
+	tok := &Token{AccessToken: "abc", TokenSecret: "def"}
+
+	req := newSignedRequest(t, "CLIENT_SECRET", tok, "POST", "http://api.example.com/statuses?include_entities=true", "status=Hello+Ladies+%2B+Gentlemen")
+	credentials, err := verifier.Verify(req)
+	if err != nil || credentials.ConsumerKey != "CLIENT_ID" || credentials.Token != "abc" {
+		t.Logf("Expecting the request to be verified but found %v (%v).", credentials, err)
+		t.FailNow()
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "status=Hello+Ladies+%2B+Gentlemen" {
+		t.Logf("Expecting the body to be readable but found %v.", string(body))
+		t.Fail()
+	}
+
+	// the same request cannot be sent twice
+	req.Body = ioutil.NopCloser(strings.NewReader("status=Hello+Ladies+%2B+Gentlemen"))
+	if _, err = verifier.Verify(req); verificationProblem(err) != "nonce_used" {
+		t.Logf("Expecting a replayed request to be rejected but found %v.", err)
+		t.Fail()
+	}
+
+	// two legged requests are signed without a token
+	if _, err = verifier.Verify(newSignedRequest(t, "CLIENT_SECRET", nil, "GET", "http://api.example.com/status", "")); err != nil {
+		t.Logf("Expecting a two legged request to be verified: %v.", err)
+		t.Fail()
+	}
+
+	tampered := newSignedRequest(t, "CLIENT_SECRET", tok, "GET", "http://api.example.com/statuses?count=1", "")
+	tampered.URL.RawQuery = "count=100"
+	tampered.RequestURI = "/statuses?count=100"
+	if _, err = verifier.Verify(tampered); verificationProblem(err) != "signature_invalid" {
+		t.Logf("Expecting a tampered request to be rejected but found %v.", err)
+		t.Fail()
+	}
+
+	if _, err = verifier.Verify(newSignedRequest(t, "WRONG_SECRET", tok, "GET", "http://api.example.com/statuses", "")); verificationProblem(err) != "signature_invalid" {
+		t.Logf("Expecting the wrong secret to be rejected but found %v.", err)
+		t.Fail()
+	}
+	if _, err = verifier.Verify(newSignedRequest(t, "CLIENT_SECRET", &Token{AccessToken: "xyz"}, "GET", "http://api.example.com/statuses", "")); verificationProblem(err) != "token_rejected" {
+		t.Logf("Expecting an unknown token to be rejected but found %v.", err)
+		t.Fail()
+	}
+	if _, err = verifier.Verify(httptest.NewRequest("GET", "http://api.example.com/statuses", nil)); verificationProblem(err) != "parameter_absent" {
+		t.Logf("Expecting an unsigned request to be rejected but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestOAuth1VerifierConcurrentReplay(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials, NonceStore: slowStateStore{newTokenCache(100, 300)}})
+	req := newSignedRequest(t, "CLIENT_SECRET", &Token{AccessToken: "abc", TokenSecret: "def"}, "GET", "http://api.example.com/statuses", "")
+
+	var accepted int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		replay := req.Clone(req.Context())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := verifier.Verify(replay); err == nil {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	if accepted != 1 {
+		t.Logf("Expecting one of the replayed requests to be accepted but was %v.", accepted)
+		t.Fail()
+	}
+}
+
+func TestOAuth1VerifierTimestamp(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials})
+	req := httptest.NewRequest("GET", "http://api.example.com/statuses", nil)
+	req.Header.Set(oauthAuthorization, `OAuth realm="Example", oauth_consumer_key="CLIENT_ID", oauth_nonce="abc", oauth_signature="sig", oauth_signature_method="HMAC-SHA1", oauth_timestamp="137131201"`)
+	if _, err := verifier.Verify(req); verificationProblem(err) != "timestamp_refused" {
+		t.Logf("Expecting an old timestamp to be rejected but found %v.", err)
+		t.Fail()
+	}
+}
+
+func TestOAuth1VerifierQueryParameters(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials})
+
+	// move the oauth parameters from the header to the query
+	signed := newSignedRequest(t, "CLIENT_SECRET", nil, "GET", "http://api.example.com/statuses?count=1", "")
+	params, err := parseAuthorizationHeader(strings.TrimPrefix(signed.Header.Get(oauthAuthorization), oauthPreamble+" "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := signed.URL.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	req := httptest.NewRequest("GET", "http://api.example.com/statuses?"+query.Encode(), nil)
+	if _, err = verifier.Verify(req); err != nil {
+		t.Logf("Expecting the query parameters to be verified: %v.", err)
+		t.Fail()
+	}
+}
+
+func TestOAuth1VerifierHandler(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials, Realm: "Example"})
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credentials, _ := OAuth1CredentialsFromContext(r.Context())
+		w.Write([]byte(credentials.ConsumerKey))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newSignedRequest(t, "CLIENT_SECRET", nil, "GET", "http://api.example.com/status", ""))
+	if rec.Code != http.StatusOK || rec.Body.String() != "CLIENT_ID" {
+		t.Logf("Expecting the request to be handled but found %v %v.", rec.Code, rec.Body.String())
+		t.Fail()
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newSignedRequest(t, "WRONG_SECRET", nil, "GET", "http://api.example.com/status", ""))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") != `OAuth realm="Example", oauth_problem="signature_invalid"` {
+		t.Logf("Expecting the request to be rejected but found %v %v.", rec.Code, rec.Header())
+		t.Fail()
+	}
+}
+
+// failingStateStore cannot store anything.
+type failingStateStore struct{}
+
+func (failingStateStore) Put(key, value string) error    { return errors.New("The store is down.") }
+func (failingStateStore) Get(key string) (string, error) { return "", errors.New("The store is down.") }
+func (failingStateStore) Delete(key string) error        { return nil }
+
+func TestOAuth1VerifierNonceStoreFailure(t *testing.T) {
+	verifier := NewOAuth1Verifier(OAuth1VerifierConfig{Credentials: verifierCredentials, NonceStore: failingStateStore{}})
+	rec := httptest.NewRecorder()
+	verifier.Handler(http.NotFoundHandler()).ServeHTTP(rec, newSignedRequest(t, "CLIENT_SECRET", nil, "GET", "http://api.example.com/status", ""))
+	if rec.Code != http.StatusInternalServerError || len(rec.Header().Get("WWW-Authenticate")) > 0 {
+		t.Logf("Expecting a server error but found %v %v.", rec.Code, rec.Header())
+		t.Fail()
+	}
+}
+
+func TestOAuth1VerifierPlaintext(t *testing.T) {
+	signed := func(target string) *http.Request {
+		req := httptest.NewRequest("GET", target, nil)
+		provider := NewOAuth1ServiceProvider(OAuth1ServiceProviderConfig{ClientID: "CLIENT_ID", ClientSecret: "CLIENT_SECRET", SignatureMethod: SignatureMethodPlaintext}).(*OAuth1ServiceProvider)
+		if err := provider.SignRequest(req, nil); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	config := OAuth1VerifierConfig{Credentials: verifierCredentials, SignatureMethods: []string{SignatureMethodPlaintext}}
+	if _, err := NewOAuth1Verifier(config).Verify(signed("http://api.example.com/status")); verificationProblem(err) != "signature_method_rejected" {
+		t.Logf("Expecting PLAINTEXT to be rejected without TLS but found %v.", err)
+		t.Fail()
+	}
+	if _, err := NewOAuth1Verifier(config).Verify(signed("https://api.example.com/status")); err != nil {
+		t.Logf("Expecting PLAINTEXT to be accepted over TLS: %v.", err)
+		t.Fail()
+	}
+	config.BaseURL = "https://api.example.com"
+	if _, err := NewOAuth1Verifier(config).Verify(signed("http://api.example.com/status")); err != nil {
+		t.Logf("Expecting PLAINTEXT to be accepted behind a TLS proxy: %v.", err)
+		t.Fail()
+	}
+}